@@ -0,0 +1,32 @@
+// Package buildinfo identifies the running build. The values are injected
+// at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/kwagmire/facial-verification-api/buildinfo.Version=v1.2.0 \
+//		-X github.com/kwagmire/facial-verification-api/buildinfo.Commit=$(git rev-parse HEAD) \
+//		-X github.com/kwagmire/facial-verification-api/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package buildinfo
+
+import "runtime"
+
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// Get returns the build information of the running binary.
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+	}
+}
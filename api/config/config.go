@@ -0,0 +1,599 @@
+package config
+
+import (
+	"image/color"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Config holds the runtime settings read from the environment at startup.
+type Config struct {
+	// Environment is either "development" or "production".
+	Environment string
+
+	// FakeDependencies replaces Cloudinary and the face microservice with
+	// in-memory fakes that accept every image and match every face, to run
+	// the API offline. NEVER enable it in production: anyone would verify
+	// as anyone. It is refused unless APP_ENV is development, so a typo'd or
+	// unset APP_ENV can't turn it on anywhere else.
+	FakeDependencies bool
+
+	// DetailedErrors controls whether internal error details (database
+	// errors, microservice response bodies...) are returned to clients.
+	// When disabled, clients only get a generic message and an error code
+	// while the full detail is logged server-side with the request ID.
+	DetailedErrors bool
+
+	// StrictJSONBody rejects request bodies with anything but whitespace
+	// after the JSON object (concatenated or double-encoded payloads).
+	StrictJSONBody bool
+
+	// MsgpackResponses lets clients get msgpack instead of JSON by sending
+	// Accept: application/msgpack. Off by default. Timeout responses are
+	// always JSON.
+	MsgpackResponses bool
+
+	// DBConnectAttempts is how many times the initial database connection
+	// is tried before giving up, and DBConnectBackoff the delay before the
+	// first retry. The delay doubles after every failed attempt.
+	DBConnectAttempts int
+	DBConnectBackoff  time.Duration
+
+	// MaxRegistrationsPerIPPerDay caps how many registrations a single IP,
+	// or integrator when an API key is used, may attempt per UTC day. Zero
+	// disables the cap. Addresses within TrustedIPs are exempt.
+	MaxRegistrationsPerIPPerDay int
+	TrustedIPs                  []netip.Prefix
+
+	// MaxConcurrentPerIP caps how many register and verify requests a single
+	// IP may have in progress at once. Zero disables the cap. Addresses within
+	// TrustedIPs are exempt.
+	MaxConcurrentPerIP int
+
+	// OneVerificationPerUser rejects a verify for a user who already has
+	// one in progress, e.g. after a double tap.
+	OneVerificationPerUser bool
+
+	// VerifyAntiSpoof runs anti-spoofing on the verification probe and
+	// rejects verifications whose probe isn't a live face. Off by default as
+	// it costs every verify an extra pass and a new 422 for clients.
+	VerifyAntiSpoof bool
+
+	// AntiSpoofMissingPolicy is what happens when the face service leaves
+	// out its anti-spoofing verdict, as older models do: "reject" the
+	// enrollment or verification, or "allow" it flagged as not evaluated.
+	AntiSpoofMissingPolicy string
+
+	// FaceMicroserviceURLs are the base URLs of the Python face service,
+	// tried in order when one is unreachable.
+	FaceMicroserviceURLs    []string
+	FaceMicroserviceTimeout time.Duration
+
+	// FaceMicroserviceSLO is the latency above which a call is logged and
+	// counted as slow. Zero disables the check.
+	FaceMicroserviceSLO time.Duration
+
+	// InferenceTimeWarning is the processing time reported by the
+	// microservice past which a warning is logged, to catch model
+	// regressions apart from network latency. Zero disables the warning.
+	InferenceTimeWarning time.Duration
+
+	// ModelVersion is recorded for verifications when the microservice
+	// doesn't report its model version. IncludeVersions adds the model and
+	// API versions to verify responses; they are always recorded in the
+	// audit table.
+	ModelVersion    string
+	IncludeVersions bool
+
+	// WaitForMicroservice delays startup until the face microservice is
+	// healthy, polling up to MicroserviceWaitAttempts times.
+	WaitForMicroservice      bool
+	MicroserviceWaitAttempts int
+	MicroserviceWaitInterval time.Duration
+
+	// FrontalMaxAngle is the largest head yaw, pitch or roll, in degrees,
+	// accepted for enrollment photos. Zero disables the check.
+	FrontalMaxAngle float64
+
+	// MinFaceFraction is the smallest face height, as a fraction of the image
+	// height, accepted for enrollment and verification photos. MinFacePixels
+	// additionally requires faces to be that many pixels wide and tall; zero
+	// disables it.
+	MinFaceFraction float64
+	MinFacePixels   int
+
+	// ForwardMaxDimension caps the longest side of images sent to the face
+	// microservice; larger ones are downscaled first, which saves bandwidth
+	// at the cost of re-encoding. Zero, the default, forwards them at full
+	// resolution. Cloudinary always gets the original.
+	ForwardMaxDimension int
+
+	// QualityWeight* weigh the signals combined into the enrollment quality
+	// score. Only their ratios matter; zero leaves a signal out.
+	QualityWeightAntiSpoof  float64
+	QualityWeightFaceSize   float64
+	QualityWeightFrontality float64
+	QualityWeightContrast   float64
+
+	// ProbeRecheck runs face detection on the probe of a non-match whose
+	// distance is over ProbeRecheckRatio times the threshold, to report
+	// probes without a usable face as such. It costs an extra call.
+	ProbeRecheck      bool
+	ProbeRecheckRatio float64
+
+	// AdminAPIKey is the bearer token required by the /admin endpoints,
+	// which are disabled when it is empty.
+	AdminAPIKey string
+
+	// FlattenPNGAlpha flattens PNGs with transparency onto FlattenBackground
+	// before they are processed, as transparent areas confuse the face model.
+	FlattenPNGAlpha   bool
+	FlattenBackground color.RGBA
+
+	// MinImageBrightness and MinImageContrast reject captures whose mean
+	// luma (0-255), or its standard deviation, is below the floor, as they
+	// give unreliable matches. Zero disables each check. RejectGrayscale
+	// also rejects black and white captures.
+	MinImageBrightness float64
+	MinImageContrast   float64
+	RejectGrayscale    bool
+
+	// StripTrailingSlash makes "/verify/" route like the canonical "/verify".
+	StripTrailingSlash bool
+
+	// MaxEnrollmentAgeDays flags verifications against enrollments older
+	// than this many days, and rejects them if RejectStaleEnrollment is set.
+	// Zero disables the check.
+	MaxEnrollmentAgeDays  int
+	RejectStaleEnrollment bool
+
+	// UserVerifyQuota caps the verifications of a single user within the
+	// rolling UserVerifyQuotaWindow, whatever their outcome. Zero disables it.
+	UserVerifyQuota       int
+	UserVerifyQuotaWindow time.Duration
+
+	// Default decision thresholds, used until they are changed at runtime
+	// through the admin API (see the thresholds package).
+	AntiSpoofMin    float64
+	MatchThreshold  float64
+	UncertaintyBand float64
+
+	// EmailHashing stores a keyed hash of each email (using EmailHashSecret)
+	// instead of the raw address. Existing rows are not converted, so it must
+	// be enabled on a fresh database.
+	EmailHashing    bool
+	EmailHashSecret string
+
+	// Hard per-route ceilings on request duration. Zero disables them.
+	RegisterTimeout time.Duration
+	VerifyTimeout   time.Duration
+	AdminTimeout    time.Duration
+
+	// ReplayWindow is how long the hash of an accepted verification probe
+	// is remembered to reject replays of the same image. Zero disables it.
+	ReplayWindow time.Duration
+
+	// MaxProbeFrames caps the frames of a burst sent to verify, which are
+	// compared at most ProbeFrameConcurrency at a time.
+	MaxProbeFrames        int
+	ProbeFrameConcurrency int
+
+	// ExactMatchFloor flags verifications whose distance is below it, as a
+	// live capture never matches its enrollment that closely: the probe is
+	// most likely the stored photo resubmitted. RejectExactMatch rejects
+	// them as spoofs. Zero disables the check.
+	ExactMatchFloor  float64
+	RejectExactMatch bool
+
+	// RoundDecimals rounds distance, threshold and time in verify responses
+	// to this many decimals, unless the client asks for ?raw=true. Zero
+	// disables rounding.
+	RoundDecimals int
+
+	// RequireAPIKey rejects register and verify requests without an
+	// X-API-Key header. Unknown keys are always rejected. Only turn it off
+	// for single-tenant deployments, as keyless requests belong to no
+	// organization.
+	RequireAPIKey bool
+
+	// MaxImageChars is the largest Base64 image string accepted, checked
+	// before any decoding work is done. Zero disables the limit.
+	MaxImageChars int
+
+	// LogPlainEmails writes raw emails in logs instead of a hash keyed by
+	// EmailHashSecret.
+	LogPlainEmails bool
+
+	// LogSampleRate logs only one in that many successful request
+	// summaries. Failures and non-matches are always logged. 1 logs
+	// everything.
+	LogSampleRate int
+
+	// RequireUniqueName forbids two users with the same first and last
+	// name, ignoring case, within an organization. The database enforces it
+	// with a unique index created at startup, and dropped when it is off;
+	// startup fails while existing users share a name.
+	RequireUniqueName bool
+
+	// RequiredFields lists the registration payload fields that must be
+	// non-empty. email and facial_image are required regardless.
+	RequiredFields []string
+
+	// EmailReservationTTL is how long /register/reserve holds an email.
+	EmailReservationTTL time.Duration
+
+	// ResultTokenSecret enables signed result tokens in verify responses,
+	// valid for ResultTokenTTL. Leave empty to disable.
+	ResultTokenSecret string
+	ResultTokenTTL    time.Duration
+
+	// StatsCacheTTL is how long GET /admin/stats results are reused.
+	StatsCacheTTL time.Duration
+
+	// ReferenceCacheBytes is the memory allowed for caching reference images,
+	// so verify can send them to the microservice instead of their URL and
+	// not wait on Cloudinary. It counts the Base64 data URIs held, about a
+	// third larger than the images themselves. Zero disables the cache.
+	ReferenceCacheBytes int
+
+	// StoreFailedProbes keeps the probe image of failed and spoofed
+	// verifications in FailedProbeFolder for fraud investigations. Off by
+	// default as these are biometric images; they are deleted after
+	// FailedProbeRetention.
+	StoreFailedProbes    bool
+	FailedProbeFolder    string
+	FailedProbeRetention time.Duration
+
+	// DeadLetterRegistrations keeps registrations that failed on upload or
+	// insert, image included, so they can be retried through the admin API.
+	// Off by default; they are deleted after DeadLetterRetention.
+	DeadLetterRegistrations bool
+	DeadLetterRetention     time.Duration
+
+	// EmbeddingsEnabled turns on POST /embed, which hands out face
+	// embeddings. They are biometric identifiers, hence off by default.
+	EmbeddingsEnabled bool
+
+	// AllowInlineReference lets verify callers pass the reference image URL
+	// instead of a user to look up. Such verifications bypass per-user
+	// quotas, the replay guard and attempt history. Only references in the
+	// caller's organization folder are accepted, which leaves out users
+	// enrolled before references were uploaded to one until they re-enroll.
+	AllowInlineReference bool
+
+	// CloudinaryDeliveryHost is the host uploaded image URLs must be served
+	// from before they are stored; a custom CNAME on private CDN setups.
+	CloudinaryDeliveryHost string
+
+	// UploadRetries is how many times a Cloudinary upload failing with a
+	// network error or a 5xx is retried, after UploadRetryBackoff, doubling
+	// every retry. Rejected uploads are never retried.
+	UploadRetries      int
+	UploadRetryBackoff time.Duration
+
+	// RunSelfTest registers the SelfTestEmail identity on boot with the
+	// SelfTestImage photo, then verifies it with SelfTestProbeImage, another
+	// photo of the same person: the enrollment photo itself would be
+	// rejected as an exact match. Both are made up under FakeDependencies.
+	// SelfTestAPIKey is sent if set. SelfTestFatal exits when it fails.
+	//
+	// The identity is a real user that persists across boots: a row in the
+	// database, with its email marked confirmed, and a reference image in
+	// Cloudinary. Registering it takes one of the day's registration slots
+	// until it exists.
+	RunSelfTest        bool
+	SelfTestFatal      bool
+	SelfTestEmail      string
+	SelfTestImage      string
+	SelfTestProbeImage string
+	SelfTestAPIKey     string
+
+	// ShutdownTimeout bounds how long in-flight requests and background
+	// tasks get to finish after SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+
+	// MaintenanceMode makes register and verify answer with a 503 until it
+	// is turned off through the admin API. MaintenanceRetryAfter is sent
+	// as the Retry-After of those responses.
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+
+	// TLSCertFile and TLSKeyFile make the API serve HTTPS, and HTTP/2 with
+	// it. EnableH2C allows HTTP/2 over plaintext instead, for running behind
+	// a proxy that terminates TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+	EnableH2C   bool
+
+	// IdleTimeout is how long a keep-alive connection may sit idle between
+	// requests. ReadHeaderTimeout bounds reading a request's headers.
+	IdleTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+
+	// NonMatchStatus is the HTTP status of a verification that ran fine but
+	// didn't match: 200 (the default) or 403. The body is the same either way.
+	NonMatchStatus int
+
+	// RequireEmailConfirmation blocks verification until the user followed
+	// the confirmation link sent at registration. Links are signed with
+	// EmailConfirmationSecret and valid for EmailConfirmationTTL.
+	RequireEmailConfirmation bool
+	EmailConfirmationSecret  string
+	EmailConfirmationTTL     time.Duration
+
+	// ConfirmationURL is the link sent to confirm an email; the token is
+	// appended to it, e.g. "https://app.example.com/confirm?token=".
+	ConfirmationURL string
+
+	// Outgoing email. Emails are only logged when SMTPHost is empty.
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// SMTPTimeout bounds a whole send, from connecting to the server to
+	// the end of the message, so a stalled server can't pile up sends.
+	SMTPTimeout time.Duration
+
+	// MicroserviceCAFile is a PEM bundle trusted, on top of the system
+	// roots, for TLS to the face microservice. MicroserviceInsecureSkipVerify
+	// turns verification off entirely, for local testing only.
+	MicroserviceCAFile             string
+	MicroserviceInsecureSkipVerify bool
+
+	// EnsembleMicroserviceURLs point to a second face microservice running
+	// an alternate model. When set, verifications run on both and the
+	// verdicts are combined according to EnsembleMode: "any" or "all" of
+	// the models must match, or "average" of their distances, each relative
+	// to its model's threshold, must be within the threshold.
+	EnsembleMicroserviceURLs []string
+	EnsembleMode             string
+
+	// EnsembleTimeout bounds the wait for the ensemble model. Past it the
+	// primary model's verdict is used alone. Zero waits as long as
+	// FaceMicroserviceTimeout allows.
+	EnsembleTimeout time.Duration
+}
+
+// App is the configuration loaded by Load.
+var App Config
+
+// Values of AntiSpoofMissingPolicy.
+const (
+	AntiSpoofReject = "reject"
+	AntiSpoofAllow  = "allow"
+)
+
+// Ways to combine the verdicts of the two models of an ensemble.
+const (
+	EnsembleAny     = "any"
+	EnsembleAll     = "all"
+	EnsembleAverage = "average"
+)
+
+func Load() {
+	env := getString("APP_ENV", "production")
+
+	App = Config{
+		Environment:    env,
+		DetailedErrors: getBool("DETAILED_ERRORS", env == "development"),
+		StrictJSONBody: getBool("STRICT_JSON_BODY", true),
+
+		MsgpackResponses: getBool("MSGPACK_RESPONSES", false),
+
+		FakeDependencies: getBool("FAKE_DEPENDENCIES", false),
+
+		DBConnectAttempts: getInt("DB_CONNECT_ATTEMPTS", 10),
+		DBConnectBackoff:  getDuration("DB_CONNECT_BACKOFF", 2*time.Second),
+
+		MaxRegistrationsPerIPPerDay: getInt("MAX_REGISTRATIONS_PER_IP_PER_DAY", 0),
+		TrustedIPs:                  getPrefixes("TRUSTED_IPS"),
+		MaxConcurrentPerIP:          getInt("MAX_CONCURRENT_PER_IP", 0),
+		OneVerificationPerUser:      getBool("ONE_VERIFICATION_PER_USER", false),
+
+		VerifyAntiSpoof:        getBool("VERIFY_ANTISPOOF", false),
+		AntiSpoofMissingPolicy: getString("ANTISPOOF_MISSING_POLICY", AntiSpoofReject),
+
+		FaceMicroserviceURLs:    getList("FACE_MICROSERVICE_URLS"),
+		FaceMicroserviceTimeout: getDuration("FACE_MICROSERVICE_TIMEOUT", 30*time.Second),
+		FaceMicroserviceSLO:     getDuration("FACE_MICROSERVICE_SLO", 2*time.Second),
+		InferenceTimeWarning:    getDuration("INFERENCE_TIME_WARNING", 5*time.Second),
+		ModelVersion:            getString("MODEL_VERSION", ""),
+		IncludeVersions:         getBool("INCLUDE_VERSIONS", true),
+
+		WaitForMicroservice:      getBool("WAIT_FOR_MICROSERVICE", false),
+		MicroserviceWaitAttempts: getInt("MICROSERVICE_WAIT_ATTEMPTS", 30),
+		MicroserviceWaitInterval: getDuration("MICROSERVICE_WAIT_INTERVAL", 2*time.Second),
+
+		FrontalMaxAngle: getFloat("FRONTAL_MAX_ANGLE", 0),
+
+		MinFaceFraction: getFloat("MIN_FACE_FRACTION", 0.5),
+		MinFacePixels:   getInt("MIN_FACE_PIXELS", 0),
+
+		ForwardMaxDimension: getInt("FORWARD_MAX_DIMENSION", 0),
+
+		QualityWeightAntiSpoof:  getFloat("ENROLLMENT_QUALITY_WEIGHT_ANTISPOOF", 0.4),
+		QualityWeightFaceSize:   getFloat("ENROLLMENT_QUALITY_WEIGHT_FACE_SIZE", 0.2),
+		QualityWeightFrontality: getFloat("ENROLLMENT_QUALITY_WEIGHT_FRONTALITY", 0.2),
+		QualityWeightContrast:   getFloat("ENROLLMENT_QUALITY_WEIGHT_CONTRAST", 0.2),
+
+		ProbeRecheck:      getBool("PROBE_RECHECK", false),
+		ProbeRecheckRatio: getFloat("PROBE_RECHECK_RATIO", 1.5),
+
+		AdminAPIKey: getString("ADMIN_API_KEY", ""),
+
+		FlattenPNGAlpha:   getBool("FLATTEN_PNG_ALPHA", true),
+		FlattenBackground: getColor("FLATTEN_BACKGROUND", color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}),
+
+		MinImageBrightness: getFloat("MIN_IMAGE_BRIGHTNESS", 0),
+		MinImageContrast:   getFloat("MIN_IMAGE_CONTRAST", 0),
+		RejectGrayscale:    getBool("REJECT_GRAYSCALE", false),
+
+		StripTrailingSlash: getBool("STRIP_TRAILING_SLASH", true),
+
+		MaxEnrollmentAgeDays:  getInt("MAX_ENROLLMENT_AGE_DAYS", 0),
+		RejectStaleEnrollment: getBool("REJECT_STALE_ENROLLMENT", false),
+
+		UserVerifyQuota:       getInt("USER_VERIFY_QUOTA", 0),
+		UserVerifyQuotaWindow: getDuration("USER_VERIFY_QUOTA_WINDOW", time.Hour),
+
+		AntiSpoofMin:    getFloat("ANTISPOOF_MIN", 0),
+		MatchThreshold:  getFloat("MATCH_THRESHOLD", 0),
+		UncertaintyBand: getFloat("UNCERTAINTY_BAND", 0),
+
+		EmailHashing:    getBool("EMAIL_HASHING", false),
+		EmailHashSecret: getString("EMAIL_HASH_SECRET", ""),
+
+		RegisterTimeout: getDuration("REGISTER_TIMEOUT", 60*time.Second),
+		VerifyTimeout:   getDuration("VERIFY_TIMEOUT", 90*time.Second),
+		AdminTimeout:    getDuration("ADMIN_TIMEOUT", 30*time.Second),
+
+		ReplayWindow: getDuration("REPLAY_WINDOW", 10*time.Minute),
+
+		MaxProbeFrames:        getInt("MAX_PROBE_FRAMES", 5),
+		ProbeFrameConcurrency: getInt("PROBE_FRAME_CONCURRENCY", 3),
+
+		ExactMatchFloor:  getFloat("EXACT_MATCH_FLOOR", 0.01),
+		RejectExactMatch: getBool("REJECT_EXACT_MATCH", false),
+
+		RoundDecimals: getInt("ROUND_DECIMALS", 0),
+
+		RequireAPIKey: getBool("REQUIRE_API_KEY", true),
+
+		MaxImageChars: getInt("MAX_IMAGE_CHARS", 14_000_000),
+
+		LogPlainEmails: getBool("LOG_PLAIN_EMAILS", false),
+		LogSampleRate:  getInt("LOG_SAMPLE_RATE", 1),
+
+		RequireUniqueName: getBool("REQUIRE_UNIQUE_NAME", false),
+		RequiredFields:    requiredFields(getList("REQUIRED_FIELDS")),
+
+		EmailReservationTTL: getDuration("EMAIL_RESERVATION_TTL", 15*time.Minute),
+
+		ResultTokenSecret: getString("RESULT_TOKEN_SECRET", ""),
+		ResultTokenTTL:    getDuration("RESULT_TOKEN_TTL", 30*time.Second),
+
+		StatsCacheTTL: getDuration("STATS_CACHE_TTL", time.Minute),
+
+		ReferenceCacheBytes: getInt("REFERENCE_CACHE_BYTES", 0),
+
+		StoreFailedProbes:    getBool("STORE_FAILED_PROBES", false),
+		FailedProbeFolder:    getString("FAILED_PROBE_FOLDER", "failed-probes"),
+		FailedProbeRetention: getDuration("FAILED_PROBE_RETENTION", 7*24*time.Hour),
+
+		DeadLetterRegistrations: getBool("DEAD_LETTER_REGISTRATIONS", false),
+		DeadLetterRetention:     getDuration("DEAD_LETTER_RETENTION", 24*time.Hour),
+
+		EmbeddingsEnabled:      getBool("EMBEDDINGS_ENABLED", false),
+		AllowInlineReference:   getBool("ALLOW_INLINE_REFERENCE", false),
+		CloudinaryDeliveryHost: getString("CLOUDINARY_DELIVERY_HOST", "res.cloudinary.com"),
+
+		UploadRetries:      getInt("UPLOAD_RETRIES", 1),
+		UploadRetryBackoff: getDuration("UPLOAD_RETRY_BACKOFF", 500*time.Millisecond),
+
+		RunSelfTest:        getBool("RUN_SELFTEST", false),
+		SelfTestFatal:      getBool("SELFTEST_FATAL", false),
+		SelfTestEmail:      getString("SELFTEST_EMAIL", "selftest@example.invalid"),
+		SelfTestImage:      getString("SELFTEST_IMAGE", ""),
+		SelfTestProbeImage: getString("SELFTEST_PROBE_IMAGE", ""),
+		SelfTestAPIKey:     getString("SELFTEST_API_KEY", ""),
+
+		ShutdownTimeout: getDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+
+		MaintenanceMode:       getBool("MAINTENANCE_MODE", false),
+		MaintenanceRetryAfter: getDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute),
+
+		TLSCertFile:       getString("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getString("TLS_KEY_FILE", ""),
+		EnableH2C:         getBool("ENABLE_H2C", false),
+		IdleTimeout:       getDuration("IDLE_TIMEOUT", 120*time.Second),
+		ReadHeaderTimeout: getDuration("READ_HEADER_TIMEOUT", 10*time.Second),
+
+		NonMatchStatus: getInt("NONMATCH_STATUS", http.StatusOK),
+
+		RequireEmailConfirmation: getBool("REQUIRE_EMAIL_CONFIRMATION", false),
+		EmailConfirmationSecret:  getString("EMAIL_CONFIRMATION_SECRET", ""),
+		EmailConfirmationTTL:     getDuration("EMAIL_CONFIRMATION_TTL", 48*time.Hour),
+
+		ConfirmationURL: getString("CONFIRMATION_URL", "http://localhost:8080/confirm?token="),
+
+		SMTPHost:     getString("SMTP_HOST", ""),
+		SMTPPort:     getInt("SMTP_PORT", 587),
+		SMTPUsername: getString("SMTP_USERNAME", ""),
+		SMTPPassword: getString("SMTP_PASSWORD", ""),
+		MailFrom:     getString("MAIL_FROM", "no-reply@localhost"),
+
+		SMTPTimeout: getDuration("SMTP_TIMEOUT", 10*time.Second),
+
+		MicroserviceCAFile:             getString("MICROSERVICE_CA_FILE", ""),
+		MicroserviceInsecureSkipVerify: getBool("MICROSERVICE_INSECURE_SKIP_VERIFY", false),
+
+		EnsembleMicroserviceURLs: getList("ENSEMBLE_MICROSERVICE_URLS"),
+		EnsembleMode:             getString("ENSEMBLE_MODE", EnsembleAll),
+		EnsembleTimeout:          getDuration("ENSEMBLE_TIMEOUT", 5*time.Second),
+	}
+
+	if App.FakeDependencies {
+		if App.Environment != "development" {
+			log.Fatalf("Error: FAKE_DEPENDENCIES is only allowed when APP_ENV is development, not %q", App.Environment)
+		}
+		log.Println("Warning: FAKE_DEPENDENCIES is on, images aren't stored and every face matches.")
+	}
+
+	// Fail at boot rather than with confusing errors on the first request
+	if missing := missingRequired(); len(missing) > 0 {
+		log.Fatalf("Error: missing required env: %s", strings.Join(missing, ", "))
+	}
+
+	if App.NonMatchStatus != http.StatusOK && App.NonMatchStatus != http.StatusForbidden {
+		log.Printf("Warning: NONMATCH_STATUS must be 200 or 403, got %d. Using 200.", App.NonMatchStatus)
+		App.NonMatchStatus = http.StatusOK
+	}
+
+	// Zero or less would turn every verification away as too many frames
+	if App.MaxProbeFrames < 1 {
+		log.Printf("Warning: MAX_PROBE_FRAMES must be at least 1, got %d. Using 1.", App.MaxProbeFrames)
+		App.MaxProbeFrames = 1
+	}
+
+	switch App.EnsembleMode {
+	case EnsembleAny, EnsembleAll, EnsembleAverage:
+	default:
+		log.Printf("Warning: invalid ENSEMBLE_MODE %q. Using %q.", App.EnsembleMode, EnsembleAll)
+		App.EnsembleMode = EnsembleAll
+	}
+
+	if App.AntiSpoofMissingPolicy != AntiSpoofReject && App.AntiSpoofMissingPolicy != AntiSpoofAllow {
+		log.Printf("Warning: invalid ANTISPOOF_MISSING_POLICY %q. Using %q.", App.AntiSpoofMissingPolicy, AntiSpoofReject)
+		App.AntiSpoofMissingPolicy = AntiSpoofReject
+	}
+
+	if len(App.FaceMicroserviceURLs) == 0 {
+		App.FaceMicroserviceURLs = []string{"http://localhost:8001"}
+	}
+}
+
+// IsTrustedIP reports whether ip falls within one of the TrustedIPs ranges.
+func (c Config) IsTrustedIP(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+
+	addr = addr.Unmap()
+	for _, prefix := range c.TrustedIPs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ServesTLS reports whether the API serves HTTPS itself.
+func (c *Config) ServesTLS() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
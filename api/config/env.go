@@ -0,0 +1,117 @@
+package config
+
+import (
+	"image/color"
+	"log"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func getString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Printf("Warning: invalid boolean %q for %s, using default %v", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid integer %q for %s, using default %d", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: invalid number %q for %s, using default %v", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func getDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: invalid duration %q for %s, using default %s", value, key, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+// getList splits a comma-separated variable, dropping empty entries.
+func getList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// getPrefixes parses a comma-separated list of IP addresses and CIDR ranges.
+func getPrefixes(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, item := range getList(key) {
+		if prefix, err := netip.ParsePrefix(item); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		log.Printf("Warning: ignoring invalid IP or CIDR %q in %s", item, key)
+	}
+	return prefixes
+}
+
+// getColor parses a "#rrggbb" hex color.
+func getColor(key string, fallback color.RGBA) color.RGBA {
+	value := strings.TrimPrefix(os.Getenv(key), "#")
+	if value == "" {
+		return fallback
+	}
+
+	rgb, err := strconv.ParseUint(value, 16, 32)
+	if err != nil || len(value) != 6 {
+		log.Printf("Warning: invalid color %q for %s, using default", value, key)
+		return fallback
+	}
+	return color.RGBA{R: uint8(rgb >> 16), G: uint8(rgb >> 8), B: uint8(rgb), A: 0xff}
+}
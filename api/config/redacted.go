@@ -0,0 +1,80 @@
+package config
+
+import (
+	"fmt"
+	"image/color"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const redactedValue = "[REDACTED]"
+
+// secretFields are the settings never shown by Redacted. Fields whose name
+// mentions a secret, password or API key are redacted as well, in case a
+// new one isn't added here.
+var secretFields = map[string]bool{
+	"AdminAPIKey":             true,
+	"EmailHashSecret":         true,
+	"ResultTokenSecret":       true,
+	"EmailConfirmationSecret": true,
+	"SMTPPassword":            true,
+}
+
+// Redacted returns the settings keyed by field name, in a form fit for
+// operators to read: secrets are replaced by a placeholder when set (empty
+// when not), durations are spelled out and credentials are stripped from
+// URLs.
+func (c *Config) Redacted() map[string]interface{} {
+	settings := map[string]interface{}{}
+
+	value := reflect.ValueOf(c).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := value.Type().Field(i).Name
+		field := value.Field(i).Interface()
+
+		if isSecretField(name) {
+			if value.Field(i).IsZero() {
+				settings[name] = ""
+			} else {
+				settings[name] = redactedValue
+			}
+			continue
+		}
+
+		switch typed := field.(type) {
+		case time.Duration:
+			settings[name] = typed.String()
+		case color.RGBA:
+			settings[name] = fmt.Sprintf("#%02x%02x%02x", typed.R, typed.G, typed.B)
+		case string:
+			settings[name] = redactURL(typed)
+		case []string:
+			redacted := make([]string, len(typed))
+			for j, item := range typed {
+				redacted[j] = redactURL(item)
+			}
+			settings[name] = redacted
+		default:
+			settings[name] = field
+		}
+	}
+	return settings
+}
+
+func isSecretField(name string) bool {
+	lower := strings.ToLower(name)
+	return secretFields[name] || strings.Contains(lower, "secret") || strings.Contains(lower, "password") || strings.HasSuffix(lower, "apikey")
+}
+
+// redactURL hides the credentials of a URL with user info, and returns any
+// other value unchanged.
+func redactURL(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.User == nil || parsed.Host == "" {
+		return value
+	}
+	parsed.User = url.User("redacted")
+	return parsed.String()
+}
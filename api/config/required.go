@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+// missingRequired lists the environment variables that must be set, given
+// the rest of the configuration, but are missing or empty.
+func missingRequired() []string {
+	var missing []string
+	require := func(name string) {
+		if strings.TrimSpace(os.Getenv(name)) == "" {
+			missing = append(missing, name)
+		}
+	}
+
+	// The DSN can also be assembled from DB_HOST and friends
+	if os.Getenv("DB_CONNECTION_STRING") == "" && os.Getenv("DB_HOST") == "" {
+		missing = append(missing, "DB_CONNECTION_STRING (or DB_HOST)")
+	}
+	if !App.FakeDependencies {
+		require("CLOUDINARY_URL")
+	}
+
+	if App.EmailHashing {
+		require("EMAIL_HASH_SECRET")
+	}
+	if App.RequireEmailConfirmation {
+		require("EMAIL_CONFIRMATION_SECRET")
+	}
+	return missing
+}
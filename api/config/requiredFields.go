@@ -0,0 +1,30 @@
+package config
+
+import (
+	"log"
+	"slices"
+)
+
+// registrationFields are the registration payload fields REQUIRED_FIELDS may
+// name. email and facial_image are always required.
+var registrationFields = []string{"email", "first_name", "last_name", "facial_image"}
+
+// requiredFields validates the configured REQUIRED_FIELDS, defaulting to all
+// registration fields.
+func requiredFields(configured []string) []string {
+	if len(configured) == 0 {
+		return registrationFields
+	}
+
+	required := []string{"email", "facial_image"}
+	for _, field := range configured {
+		if !slices.Contains(registrationFields, field) {
+			log.Printf("Warning: unknown registration field %q in REQUIRED_FIELDS, ignoring it.", field)
+			continue
+		}
+		if !slices.Contains(required, field) {
+			required = append(required, field)
+		}
+	}
+	return required
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/pressly/goose/v3"
+)
+
+// checkMigrations makes sure the migration files and the versions recorded
+// in the database line up before anything is applied. Cherry-picking
+// migrations between branches easily leaves a gap in the numbering, a
+// version applied from a branch whose file is missing here, or a file older
+// than the current version that was never applied. goose would apply or
+// skip those silently, so they stop startup instead.
+func checkMigrations() error {
+	migrations, err := goose.CollectMigrations(migrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return err
+	}
+
+	current, err := goose.EnsureDBVersion(DB)
+	if err != nil {
+		return err
+	}
+
+	files := make([]string, len(migrations))
+	available := map[int64]bool{}
+	for i, migration := range migrations {
+		files[i] = path.Base(migration.Source)
+		available[migration.Version] = true
+
+		// Versions are numbered from 1 without gaps
+		if want := int64(i + 1); migration.Version != want {
+			return fmt.Errorf("expected migration version %d, found %s", want, files[i])
+		}
+	}
+	log.Printf("Database at migration version %d, available: %s", current, strings.Join(files, ", "))
+
+	// The latest row for each version tells whether it is applied, as rolling
+	// back adds a row rather than deleting one
+	rows, err := DB.Query(`
+		SELECT DISTINCT ON (version_id) version_id, is_applied
+		FROM goose_db_version
+		WHERE version_id > 0
+		ORDER BY version_id, id DESC`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return err
+		}
+		if !isApplied {
+			continue
+		}
+		if !available[version] {
+			return fmt.Errorf("database has migration %d applied, but there is no file for it", version)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if migration.Version < current && !applied[migration.Version] {
+			return fmt.Errorf("migration %d is older than the database version %d but was never applied", migration.Version, current)
+		}
+	}
+	return nil
+}
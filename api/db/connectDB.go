@@ -4,17 +4,27 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
 	"os"
+	"time"
 
+	"github.com/kwagmire/facial-verification-api/config"
 	_ "github.com/lib/pq"
 )
 
+// maxConnectBackoff caps the delay between two connection attempts.
+const maxConnectBackoff = 30 * time.Second
+
 var DB *sql.DB
 
 func ConnectDB() error {
 	connStr := os.Getenv("DB_CONNECTION_STRING")
 	if connStr == "" {
-		log.Fatal("Error: DB_CONNECTION_STRING environment variable not set.")
+		connStr = connectionStringFromParts()
+	}
+	if connStr == "" {
+		log.Fatal("Error: neither DB_CONNECTION_STRING nor DB_HOST environment variable set.")
 	}
 
 	var err error
@@ -23,11 +33,55 @@ func ConnectDB() error {
 		return fmt.Errorf("failed to open database connection: %w", err)
 	}
 
-	err = DB.Ping()
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
+	// The database may still be starting up (e.g. in docker-compose), so
+	// retry the initial ping with an exponential backoff before giving up.
+	attempts := max(config.App.DBConnectAttempts, 1)
+	backoff := config.App.DBConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = DB.Ping()
+		if err == nil {
+			break
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("failed to connect to database after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("Database not ready (attempt %d/%d): %v. Retrying in %s...", attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
 
 	fmt.Println("Successfully connected to PostgreSQL!")
 	return nil
 }
+
+// connectionStringFromParts builds a postgres URL from DB_HOST, DB_PORT,
+// DB_USER, DB_PASSWORD, DB_NAME and DB_SSLMODE, as provided by e.g.
+// Kubernetes secrets. It returns "" when DB_HOST isn't set. Credentials are
+// escaped by net/url, so passwords may contain any character.
+func connectionStringFromParts() string {
+	host := os.Getenv("DB_HOST")
+	if host == "" {
+		return ""
+	}
+	if port := os.Getenv("DB_PORT"); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		Host:   host,
+		Path:   "/" + os.Getenv("DB_NAME"),
+	}
+	if user := os.Getenv("DB_USER"); user != "" {
+		if password, ok := os.LookupEnv("DB_PASSWORD"); ok {
+			dsn.User = url.UserPassword(user, password)
+		} else {
+			dsn.User = url.User(user)
+		}
+	}
+	if sslMode := os.Getenv("DB_SSLMODE"); sslMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {sslMode}}.Encode()
+	}
+	return dsn.String()
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE registration_counts (
+	ip VARCHAR(45) NOT NULL,
+	day DATE NOT NULL,
+	count INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (ip, day)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS registration_counts;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users
+	ADD COLUMN regimage_public_id VARCHAR(255),
+	ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE TABLE verification_attempts (
+	id SERIAL PRIMARY KEY,
+	user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	outcome VARCHAR(30) NOT NULL,
+	is_match BOOLEAN,
+	distance DOUBLE PRECISION,
+	threshold DOUBLE PRECISION,
+	client_ip VARCHAR(45),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX verification_attempts_user_id_created_at_idx
+	ON verification_attempts (user_id, created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS verification_attempts;
+
+ALTER TABLE users
+	DROP COLUMN IF EXISTS regimage_public_id,
+	DROP COLUMN IF EXISTS created_at;
+-- +goose StatementEnd
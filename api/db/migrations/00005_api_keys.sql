@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE organizations (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(100) UNIQUE NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE api_keys (
+	id SERIAL PRIMARY KEY,
+	org_id INTEGER NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	key_hash CHAR(64) UNIQUE NOT NULL,
+	request_count BIGINT NOT NULL DEFAULT 0,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Quotas are keyed by integrator when an API key is used, by IP otherwise
+ALTER TABLE registration_counts RENAME COLUMN ip TO subject;
+ALTER TABLE registration_counts ALTER COLUMN subject TYPE VARCHAR(64);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE registration_counts ALTER COLUMN subject TYPE VARCHAR(45);
+ALTER TABLE registration_counts RENAME COLUMN subject TO ip;
+
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS organizations;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE INDEX users_created_at_idx ON users (created_at);
+CREATE INDEX verification_attempts_created_at_idx ON verification_attempts (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX verification_attempts_created_at_idx;
+DROP INDEX users_created_at_idx;
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Cloudinary public ID of the probe of a failed attempt, kept for fraud
+-- investigations when STORE_FAILED_PROBES is on. Cleared on expiry.
+ALTER TABLE verification_attempts ADD COLUMN probe_public_id VARCHAR(255);
+CREATE INDEX verification_attempts_probe_idx ON verification_attempts (created_at)
+    WHERE probe_public_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX verification_attempts_probe_idx;
+ALTER TABLE verification_attempts DROP COLUMN probe_public_id;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Users registered before confirmation existed are grandfathered in, only
+-- new ones start unconfirmed
+ALTER TABLE users ADD COLUMN email_confirmed BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE users ALTER COLUMN email_confirmed SET DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN email_confirmed;
+-- +goose StatementEnd
@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- Deleted users no longer hold on to their email
+DROP INDEX users_org_id_email_key;
+CREATE UNIQUE INDEX users_org_id_email_key ON users (COALESCE(org_id, 0), email)
+    WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DROP INDEX users_org_id_email_key;
+CREATE UNIQUE INDEX users_org_id_email_key ON users (COALESCE(org_id, 0), email);
+ALTER TABLE users DROP COLUMN deleted_at;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Anti-spoofing score of the reference image, compared with the probe's at
+-- verification. NULL for users enrolled before it was recorded.
+ALTER TABLE users ADD COLUMN enrollment_antispoof_score DOUBLE PRECISION;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN enrollment_antispoof_score;
+-- +goose StatementEnd
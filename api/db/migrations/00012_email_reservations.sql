@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Emails held for a signup in progress. Expired rows are ignored and
+-- eventually overwritten or purged.
+CREATE TABLE email_reservations (
+	id SERIAL PRIMARY KEY,
+	org_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE,
+	email VARCHAR(100) NOT NULL,
+	token_hash CHAR(64) NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE UNIQUE INDEX email_reservations_org_id_email_key ON email_reservations (COALESCE(org_id, 0), email);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS email_reservations;
+-- +goose StatementEnd
@@ -0,0 +1,12 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Identifies an enrollment event, so a verification can be traced back to
+-- the registration (or face update) it was compared against. Renewed on
+-- every re-enrollment.
+ALTER TABLE users ADD COLUMN enrollment_id UUID NOT NULL DEFAULT gen_random_uuid();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN enrollment_id;
+-- +goose StatementEnd
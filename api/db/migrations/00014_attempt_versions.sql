@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Which model and API build judged each attempt, to explain past decisions
+-- after upgrades. NULL for attempts recorded before they were tracked.
+ALTER TABLE verification_attempts
+	ADD COLUMN model_version VARCHAR(100),
+	ADD COLUMN api_version VARCHAR(50);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE verification_attempts
+	DROP COLUMN model_version,
+	DROP COLUMN api_version;
+-- +goose StatementEnd
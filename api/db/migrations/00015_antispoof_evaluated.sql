@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- False when the face service gave no anti-spoofing verdict for the
+-- reference image and ANTISPOOF_MISSING_POLICY let the enrollment through.
+ALTER TABLE users ADD COLUMN antispoof_evaluated BOOLEAN NOT NULL DEFAULT true;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN antispoof_evaluated;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Per-organization overrides of the decision thresholds. Fields left out,
+-- or a NULL, fall back to the global thresholds.
+ALTER TABLE organizations ADD COLUMN thresholds JSONB;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE organizations DROP COLUMN thresholds;
+-- +goose StatementEnd
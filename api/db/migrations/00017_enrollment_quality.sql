@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- 0-100 score of the reference image, computed from the detection signals
+-- at enrollment. NULL for users enrolled before it was recorded.
+ALTER TABLE users ADD COLUMN enrollment_quality SMALLINT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN enrollment_quality;
+-- +goose StatementEnd
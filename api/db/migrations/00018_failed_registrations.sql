@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Registrations that passed the face checks but failed on upload or insert,
+-- kept when DEAD_LETTER_REGISTRATIONS is on so they can be retried. email
+-- is in its stored form and image is the processed reference, so rows are
+-- purged after DEAD_LETTER_RETENTION.
+CREATE TABLE failed_registrations (
+	id SERIAL PRIMARY KEY,
+	org_id INTEGER REFERENCES organizations(id) ON DELETE CASCADE,
+	email VARCHAR(100) NOT NULL,
+	first_name VARCHAR(50) NOT NULL,
+	last_name VARCHAR(50) NOT NULL,
+	image TEXT NOT NULL,
+	antispoof_score DOUBLE PRECISION,
+	enrollment_quality SMALLINT,
+	error TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX failed_registrations_created_at_idx ON failed_registrations (created_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS failed_registrations;
+-- +goose StatementEnd
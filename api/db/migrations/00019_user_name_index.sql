@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Looks up users by name within an organization, for REQUIRE_UNIQUE_NAME.
+-- Replaces the unique index that used to be created at startup when it was
+-- on; uniqueness is now checked on insert so it can stay opt-in.
+DROP INDEX IF EXISTS users_org_id_name_key;
+CREATE INDEX users_org_id_name_idx ON users (COALESCE(org_id, 0), lower(first_name), lower(last_name)) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_org_id_name_idx;
+-- +goose StatementEnd
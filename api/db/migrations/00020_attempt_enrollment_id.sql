@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- The enrollment each attempt was compared against (users.enrollment_id at
+-- the time). NULL for attempts recorded before it was tracked.
+ALTER TABLE verification_attempts ADD COLUMN enrollment_id UUID;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE verification_attempts DROP COLUMN enrollment_id;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+-- REQUIRE_UNIQUE_NAME is enforced by the unique index SyncUniqueNameIndex
+-- keeps at startup, which serves the name lookups this one was for.
+DROP INDEX IF EXISTS users_org_id_name_idx;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+CREATE INDEX users_org_id_name_idx ON users (COALESCE(org_id, 0), lower(first_name), lower(last_name)) WHERE deleted_at IS NULL;
+-- +goose StatementEnd
@@ -1,18 +1,30 @@
 package db
 
 import (
+	"embed"
 	"log"
 
 	"github.com/pressly/goose/v3"
 )
 
+// Embedded so the binary doesn't depend on the working directory it is
+// started from.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+const migrationsDir = "migrations"
+
+// RunMigrations applies pending migrations. ConnectDB must be called first.
 func RunMigrations() {
-	ConnectDB()
-	// Specify the directory where your migration files are located
-	//goose.SetDir("./migrations")
+	goose.SetBaseFS(migrationsFS)
+
+	if err := checkMigrations(); err != nil {
+		log.Fatalf("goose: refusing to migrate: %v", err)
+	}
 
 	// Run the migrations
-	if err := goose.Up(DB, "./db/migrations"); err != nil {
+	if err := goose.Up(DB, migrationsDir); err != nil {
 		log.Fatalf("goose: failed to run migrations: %v\n", err)
 	}
 
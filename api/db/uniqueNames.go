@@ -0,0 +1,49 @@
+package db
+
+import (
+	"fmt"
+	"log"
+)
+
+// UniqueNameIndex is the name of the unique index on user names within an
+// organization, ignoring case, that exists while REQUIRE_UNIQUE_NAME is on.
+const UniqueNameIndex = "users_org_id_name_key"
+
+// SyncUniqueNameIndex creates or drops UniqueNameIndex so it matches
+// REQUIRE_UNIQUE_NAME. Names are only unique for deployments that opt in,
+// which a migration can't express, but the database still has to enforce
+// it for every writer, not only the register handler. It must be called
+// after RunMigrations. Replicas starting together take turns.
+func SyncUniqueNameIndex(required bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, UniqueNameIndex); err != nil {
+		return err
+	}
+
+	query := `DROP INDEX IF EXISTS ` + UniqueNameIndex
+	if required {
+		query = `
+			CREATE UNIQUE INDEX IF NOT EXISTS ` + UniqueNameIndex + `
+			ON users (COALESCE(org_id, 0), lower(first_name), lower(last_name))
+			WHERE deleted_at IS NULL`
+	}
+	if _, err := tx.Exec(query); err != nil {
+		if required {
+			return fmt.Errorf("creating %s, remove duplicate names or turn off REQUIRE_UNIQUE_NAME: %w", UniqueNameIndex, err)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if required {
+		log.Println("Unique user names per organization are enforced.")
+	}
+	return nil
+}
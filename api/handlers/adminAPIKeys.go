@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/models"
+	"github.com/lib/pq"
+)
+
+// CreateOrganization registers a new integrator organization.
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.CreateOrganizationPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Name == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+
+	var orgID int
+	err := db.DB.QueryRow(`INSERT INTO organizations (name) VALUES ($1) RETURNING id`, thisRequest.Name).Scan(&orgID)
+	if err != nil {
+		if dbError, ok := err.(*pq.Error); ok && dbError.Code.Name() == "unique_violation" {
+			respondWithError(w, r, codeOrganizationExists, "Organization already exists", http.StatusConflict)
+			return
+		}
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to create organization", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusCreated, map[string]interface{}{"id": orgID, "name": thisRequest.Name})
+}
+
+// CreateAPIKey issues a new API key for an organization. The key is only
+// ever returned here; just its hash is stored.
+func CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	var thisRequest models.CreateAPIKeyPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Name == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		respondWithInternalError(w, r, codeInternalError, "Failed to generate API key", err, http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		INSERT INTO api_keys (org_id, name, key_hash)
+		VALUES ($1, $2, $3)
+		RETURNING id`
+	var keyID int
+	err = db.DB.QueryRow(query, orgID, thisRequest.Name, hashAPIKey(key)).Scan(&keyID)
+	if err != nil {
+		if dbError, ok := err.(*pq.Error); ok && dbError.Code.Name() == "foreign_key_violation" {
+			respondWithError(w, r, codeOrganizationNotFound, "Organization doesn't exist", http.StatusNotFound)
+			return
+		}
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to create API key", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusCreated, map[string]interface{}{"id": keyID, "org_id": orgID, "key": key})
+}
+
+// ListAPIKeys returns every API key with its usage, for billing.
+func ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	query := `
+		SELECT
+			id,
+			org_id,
+			name,
+			request_count,
+			last_used_at,
+			revoked_at IS NOT NULL,
+			created_at
+		FROM api_keys
+		ORDER BY id`
+	rows, err := db.DB.Query(query)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKeyUsage{}
+	for rows.Next() {
+		var key models.APIKeyUsage
+		err = rows.Scan(&key.ID, &key.OrgID, &key.Name, &key.RequestCount, &key.LastUsedAt, &key.Revoked, &key.CreatedAt)
+		if err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, key)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, keys)
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// AdminOnly restricts next to callers presenting ADMIN_API_KEY as a bearer
+// token. Admin endpoints are disabled altogether when no key is configured.
+func AdminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if config.App.AdminAPIKey == "" {
+			respondWithError(w, r, codeForbidden, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(config.App.AdminAPIKey)) != 1 {
+			respondWithError(w, r, codeUnauthorized, "Invalid or missing admin credentials", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, r)
+	}
+}
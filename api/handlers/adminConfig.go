@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/kwagmire/facial-verification-api/buildinfo"
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// GetConfig returns the configuration the service is running with, secrets
+// redacted, to compare environments without shell access.
+func GetConfig(w http.ResponseWriter, r *http.Request) {
+	storage := map[string]string{"backend": "cloudinary"}
+	if config.App.FakeDependencies {
+		storage["backend"] = "fake"
+	} else if parsed, err := url.Parse(os.Getenv("CLOUDINARY_URL")); err == nil {
+		// Only the cloud name, the URL also carries the API key and secret
+		storage["cloud_name"] = parsed.Host
+	}
+
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{
+		"settings":   config.App.Redacted(),
+		"thresholds": thresholds.Get(),
+		"storage":    storage,
+		"build":      buildinfo.Get(),
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// cachedStats holds the last computed stats, since dashboards poll and the
+// counts don't need to be exact.
+var cachedStats struct {
+	sync.Mutex
+	stats      models.UserStats
+	computedAt time.Time
+}
+
+// GetStats returns headline user and verification counts. "Today" is the
+// current UTC day.
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	cachedStats.Lock()
+	stats, computedAt := cachedStats.stats, cachedStats.computedAt
+	cachedStats.Unlock()
+
+	if time.Since(computedAt) < config.App.StatsCacheTTL {
+		respondWithJSON(w, r, http.StatusOK, stats)
+		return
+	}
+
+	// Queried without holding the lock, so a slow query doesn't hold up
+	// every other dashboard. Concurrent misses may each run it.
+	query := `
+		SELECT
+			(SELECT count(*) FROM users WHERE deleted_at IS NULL),
+			(SELECT count(DISTINCT user_id) FROM verification_attempts
+				WHERE created_at >= now() - interval '30 days'),
+			(SELECT count(*) FROM users
+				WHERE created_at >= (now() AT TIME ZONE 'UTC')::date AT TIME ZONE 'UTC'),
+			(SELECT count(*) FROM verification_attempts
+				WHERE created_at >= (now() AT TIME ZONE 'UTC')::date AT TIME ZONE 'UTC')`
+	err := db.DB.QueryRowContext(r.Context(), query).Scan(
+		&stats.TotalUsers,
+		&stats.ActiveUsers30d,
+		&stats.RegistrationsToday,
+		&stats.VerificationsToday,
+	)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	cachedStats.Lock()
+	cachedStats.stats = stats
+	cachedStats.computedAt = time.Now()
+	cachedStats.Unlock()
+
+	respondWithJSON(w, r, http.StatusOK, stats)
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// GetThresholds returns the decision thresholds currently in effect.
+func GetThresholds(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, thresholds.Get())
+}
+
+// UpdateThresholds changes the decision thresholds at runtime. Fields
+// missing from the payload keep their current value.
+func UpdateThresholds(w http.ResponseWriter, r *http.Request) {
+	var patch json.RawMessage
+	if !decodeJSONBody(w, r, &patch) {
+		return
+	}
+
+	// Merged into the thresholds current at the time of the update
+	updated, err := thresholds.Modify(func(t *thresholds.Thresholds) error {
+		return json.Unmarshal(patch, t)
+	})
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		respondWithError(w, r, codeInvalidRequest, "Invalid request payload", http.StatusBadRequest)
+		return
+	}
+	var validationErr thresholds.ValidationError
+	if errors.As(err, &validationErr) {
+		respondWithErrorFields(w, r, codeInvalidRequest, "Invalid thresholds", http.StatusBadRequest,
+			map[string]interface{}{"detail": validationErr.Error()})
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to save thresholds", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, updated)
+}
+
+// GetOrgThresholds returns an organization's threshold override along with
+// the thresholds in effect for it.
+func GetOrgThresholds(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	override, _ := thresholds.GetOverride(orgID)
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{
+		"override":  override,
+		"effective": thresholds.ForOrg(&orgID),
+	})
+}
+
+// UpdateOrgThresholds replaces an organization's threshold override. Fields
+// left out of the payload fall back to the global thresholds, so an empty
+// payload removes the override.
+func UpdateOrgThresholds(w http.ResponseWriter, r *http.Request) {
+	orgID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	var override thresholds.Override
+	if !decodeJSONBody(w, r, &override) {
+		return
+	}
+
+	err = thresholds.UpdateOverride(orgID, override)
+	var validationErr thresholds.ValidationError
+	if errors.As(err, &validationErr) {
+		respondWithErrorFields(w, r, codeInvalidRequest, "Invalid thresholds", http.StatusBadRequest,
+			map[string]interface{}{"detail": validationErr.Error()})
+		return
+	}
+	if errors.Is(err, thresholds.ErrOrganizationNotFound) {
+		respondWithError(w, r, codeOrganizationNotFound, "Organization not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to save thresholds", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{
+		"override":  override,
+		"effective": thresholds.ForOrg(&orgID),
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// tasks tracks work that can outlive the HTTP response: handlers abandoned
+// by WithTimeout (still mid-call to the microservice or writing the audit
+// trail) and background jobs. server.Shutdown only waits for the former
+// while their response is pending.
+var tasks sync.WaitGroup
+
+// track counts h as a task until it returns.
+func track(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tasks.Add(1)
+		defer tasks.Done()
+		h(w, r)
+	}
+}
+
+// goBackground runs task in its own goroutine, counted as a task.
+func goBackground(task func()) {
+	tasks.Add(1)
+	go func() {
+		defer tasks.Done()
+		task()
+	}()
+}
+
+// WaitForTasks blocks until every tracked task is done or ctx expires.
+func WaitForTasks(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		tasks.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// bestEffort runs a step that must not fail the request it belongs to, such
+// as a notification. Errors and panics are logged and swallowed.
+func bestEffort(requestID string, step string, fn func() error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("request_id=%s: %s panicked: %v", requestID, step, recovered)
+		}
+	}()
+
+	if err := fn(); err != nil {
+		log.Printf("request_id=%s: %s failed: %v", requestID, step, err)
+	}
+}
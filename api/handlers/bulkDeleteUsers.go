@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+	"github.com/lib/pq"
+)
+
+// assetDeleteBatch is the most public IDs Cloudinary deletes in one call.
+const assetDeleteBatch = 100
+
+// BulkDeleteUsers soft-deletes every user matching all the given filters and
+// removes their reference images. Without "confirm": true it only reports
+// how many users would be deleted.
+func BulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.BulkDeleteUsersPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+
+	// An empty filter would match everyone
+	if thisRequest.OrgID == nil && thisRequest.CreatedBefore == nil && len(thisRequest.Emails) == 0 {
+		respondWithError(w, r, codeMissingFields, "At least one filter is required", http.StatusBadRequest)
+		return
+	}
+
+	conditions := []string{"deleted_at IS NULL"}
+	var args []interface{}
+	if thisRequest.OrgID != nil {
+		args = append(args, *thisRequest.OrgID)
+		conditions = append(conditions, fmt.Sprintf("org_id = $%d", len(args)))
+	}
+	if thisRequest.CreatedBefore != nil {
+		args = append(args, *thisRequest.CreatedBefore)
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if len(thisRequest.Emails) > 0 {
+		emails := make([]string, len(thisRequest.Emails))
+		for i, email := range thisRequest.Emails {
+			emails[i] = storedEmail(email)
+		}
+		args = append(args, pq.Array(emails))
+		conditions = append(conditions, fmt.Sprintf("email = ANY($%d)", len(args)))
+	}
+	where := strings.Join(conditions, " AND ")
+
+	if !thisRequest.Confirm {
+		var matched int
+		err := db.DB.QueryRow(`SELECT count(*) FROM users WHERE `+where, args...).Scan(&matched)
+		if err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(w, r, http.StatusOK, map[string]interface{}{"dry_run": true, "matched": matched})
+		return
+	}
+
+	query := `
+		UPDATE users
+		SET deleted_at = now()
+		WHERE ` + where + `
+		RETURNING regimage_public_id`
+	rows, err := db.DB.Query(query, args...)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to delete users", err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	deleted := 0
+	var publicIDs []string
+	for rows.Next() {
+		var publicID *string
+		if err = rows.Scan(&publicID); err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		deleted++
+		if publicID != nil {
+			publicIDs = append(publicIDs, *publicID)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Users are already deleted at this point, so failed asset deletions
+	// are only logged and reported.
+	assetsDeleted := deleteAssets(r, publicIDs)
+
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{
+		"dry_run":        false,
+		"deleted":        deleted,
+		"assets_deleted": assetsDeleted,
+	})
+}
+
+// deleteAssets removes the given images from Cloudinary in batches and
+// returns how many were deleted.
+func deleteAssets(r *http.Request, publicIDs []string) int {
+	if len(publicIDs) == 0 {
+		return 0
+	}
+	requestID := middleware.GetRequestID(r.Context())
+
+	deleted := 0
+	for start := 0; start < len(publicIDs); start += assetDeleteBatch {
+		batch := publicIDs[start:min(start+assetDeleteBatch, len(publicIDs))]
+		result, err := imagestore.Store.DeleteAssets(context.Background(), admin.DeleteAssetsParams{
+			PublicIDs:  batch,
+			Invalidate: api.Bool(true),
+		})
+		if err == nil && result.Error.Message != "" {
+			err = fmt.Errorf("%s", result.Error.Message)
+		}
+		if err != nil {
+			log.Printf("request_id=%s: failed to delete %d assets: %v", requestID, len(batch), err)
+			continue
+		}
+
+		for _, status := range result.Deleted {
+			if status == "deleted" {
+				deleted++
+			}
+		}
+	}
+	return deleted
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// inFlightPerIP counts the requests each client IP has in progress.
+var inFlightPerIP = struct {
+	mu    sync.Mutex
+	count map[string]int
+}{count: make(map[string]int)}
+
+// LimitPerIP rejects a request with a 429 when its client IP already has
+// MAX_CONCURRENT_PER_IP requests in progress, so one client can't take all
+// of the face microservice's capacity with simultaneous calls. Trusted IPs
+// aren't limited. The slot is held until h returns, even past a timeout, as
+// that is when the downstream work actually stops.
+func LimitPerIP(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := config.App.MaxConcurrentPerIP
+		ip := clientIP(r)
+		if limit <= 0 || config.App.IsTrustedIP(ip) {
+			h(w, r)
+			return
+		}
+
+		if !acquireIPSlot(ip, limit) {
+			respondWithError(w, r, codeTooManyConcurrent, "Too many simultaneous requests, please wait for the previous ones to finish", http.StatusTooManyRequests)
+			return
+		}
+		defer releaseIPSlot(ip)
+		h(w, r)
+	}
+}
+
+func acquireIPSlot(ip string, limit int) bool {
+	inFlightPerIP.mu.Lock()
+	defer inFlightPerIP.mu.Unlock()
+
+	if inFlightPerIP.count[ip] >= limit {
+		return false
+	}
+	inFlightPerIP.count[ip]++
+	return true
+}
+
+func releaseIPSlot(ip string) {
+	inFlightPerIP.mu.Lock()
+	defer inFlightPerIP.mu.Unlock()
+
+	// Dropped at zero so the map only holds IPs with requests in progress
+	if inFlightPerIP.count[ip]--; inFlightPerIP.count[ip] <= 0 {
+		delete(inFlightPerIP.count, ip)
+	}
+}
+
+// verifyingUsers holds the users with a verification in progress, when
+// ONE_VERIFICATION_PER_USER is on.
+var verifyingUsers = struct {
+	mu  sync.Mutex
+	ids map[int]bool
+}{ids: make(map[int]bool)}
+
+// lockUserVerification marks userID as being verified and returns false if
+// it already was. A double tap would otherwise run two verifications whose
+// results can disagree.
+func lockUserVerification(userID int) bool {
+	verifyingUsers.mu.Lock()
+	defer verifyingUsers.mu.Unlock()
+
+	if verifyingUsers.ids[userID] {
+		return false
+	}
+	verifyingUsers.ids[userID] = true
+	return true
+}
+
+func unlockUserVerification(userID int) {
+	verifyingUsers.mu.Lock()
+	defer verifyingUsers.mu.Unlock()
+	delete(verifyingUsers.ids, userID)
+}
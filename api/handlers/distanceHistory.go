@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+const (
+	defaultHistoryLimit = 100
+	maxHistoryLimit     = 1000
+)
+
+// GetDistanceHistory returns the distances of a user's most recent scored
+// verifications, oldest first, to spot matches degrading over time.
+func GetDistanceHistory(w http.ResponseWriter, r *http.Request) {
+	orgID, err := requestedOrgID(r)
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			respondWithError(w, r, codeInvalidRequest, "Invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = min(limit, maxHistoryLimit)
+	}
+
+	user, err := fetchUser(orgID, r.PathValue("email"))
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Attempts that failed before scoring have no distance
+	query := `
+		SELECT distance, threshold, is_match, created_at
+		FROM (
+			SELECT distance, threshold, is_match, created_at
+			FROM verification_attempts
+			WHERE user_id = $1 AND distance IS NOT NULL
+			ORDER BY created_at DESC
+			LIMIT $2
+		) recent
+		ORDER BY created_at`
+	rows, err := db.DB.Query(query, user.ID, limit)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	history := []models.DistancePoint{}
+	for rows.Next() {
+		var point models.DistancePoint
+		if err = rows.Scan(&point.Distance, &point.Threshold, &point.IsMatch, &point.CreatedAt); err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		history = append(history, point)
+	}
+	if err = rows.Err(); err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, history)
+}
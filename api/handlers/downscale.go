@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/imageproc"
+	"github.com/kwagmire/facial-verification-api/microservice"
+)
+
+// prepareForService runs a client image through imageproc.Prepare. It
+// returns the processed image, to store, and the one to send to the face
+// microservice, shrunk to FORWARD_MAX_DIMENSION since the microservice would
+// downscale it anyway, along with the factor it was scaled by.
+func prepareForService(encoded string) (processed, forwarded string, scale float64, err error) {
+	data, err := imageproc.Decode(encoded)
+	if err != nil {
+		return "", "", 1, err
+	}
+	return imageproc.Prepare(encoded, data, config.App.ForwardMaxDimension)
+}
+
+// originalSize converts a size measured on a downscaled image back to the
+// dimensions of the image the client sent.
+func originalSize(size *microservice.Size, scale float64) *microservice.Size {
+	if size == nil || scale == 1 {
+		return size
+	}
+	return &microservice.Size{
+		Width:  int(float64(size.Width)/scale + 0.5),
+		Height: int(float64(size.Height)/scale + 0.5),
+	}
+}
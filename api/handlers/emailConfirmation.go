@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/mailer"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+var errInvalidConfirmationToken = errors.New("invalid confirmation token")
+
+// confirmationToken returns a token proving control of the user's email,
+// valid for EMAIL_CONFIRMATION_TTL. It has the form "<user id>.<expiry>.<mac>"
+// so it can be put in a link as-is.
+func confirmationToken(userID int, now time.Time) string {
+	payload := fmt.Sprintf("%d.%d", userID, now.Add(config.App.EmailConfirmationTTL).Unix())
+	return payload + "." + confirmationMAC(payload)
+}
+
+// parseConfirmationToken checks token and returns the user ID it confirms.
+func parseConfirmationToken(token string, now time.Time) (int, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return 0, errInvalidConfirmationToken
+	}
+	payload, mac := token[:separator], token[separator+1:]
+	if !hmac.Equal([]byte(mac), []byte(confirmationMAC(payload))) {
+		return 0, errInvalidConfirmationToken
+	}
+
+	id, expiry, _ := strings.Cut(payload, ".")
+	userID, err := strconv.Atoi(id)
+	if err != nil {
+		return 0, errInvalidConfirmationToken
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= expiresAt {
+		return 0, errInvalidConfirmationToken
+	}
+	return userID, nil
+}
+
+// sendConfirmationEmail mails the confirmation link in the background, so
+// registration doesn't wait on the mail server. A failed send is only logged.
+func sendConfirmationEmail(r *http.Request, email, token string) {
+	requestID := middleware.GetRequestID(r.Context())
+	body := "Please confirm your email address by opening this link:\n\n" +
+		config.App.ConfirmationURL + url.QueryEscape(token) + "\n\n" +
+		"The link expires in " + config.App.EmailConfirmationTTL.String() + "."
+
+	goBackground(func() {
+		bestEffort(requestID, "confirmation email", func() error {
+			return mailer.Default.Send(email, "Confirm your email address", body)
+		})
+	})
+}
+
+func confirmationMAC(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.App.EmailConfirmationSecret))
+	mac.Write([]byte("confirm:" + payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ConfirmEmail marks a user's email as confirmed from the token sent to it.
+func ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.ConfirmEmailPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Token == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := parseConfirmationToken(thisRequest.Token, time.Now())
+	if err != nil {
+		respondWithError(w, r, codeInvalidToken, "Invalid or expired confirmation link", http.StatusBadRequest)
+		return
+	}
+
+	result, err := db.DB.Exec(`UPDATE users SET email_confirmed = true WHERE id = $1 AND deleted_at IS NULL`, userID)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to confirm email", err, http.StatusInternalServerError)
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, map[string]string{"message": "Email confirmed!"})
+}
+
+// ResendConfirmation mails a new confirmation link to a user who lost the
+// first one or let it expire.
+func ResendConfirmation(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.ResendConfirmationPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Email == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+
+	var userID int
+	var confirmed bool
+	err := db.DB.QueryRowContext(r.Context(), `
+		SELECT id, email_confirmed
+		FROM users
+		WHERE email = $1 AND org_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL`,
+		storedEmail(thisRequest.Email), callerOrgID(r),
+	).Scan(&userID, &confirmed)
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+	if confirmed {
+		respondWithError(w, r, codeEmailAlreadyConfirmed, "Email is already confirmed", http.StatusConflict)
+		return
+	}
+
+	sendConfirmationEmail(r, thisRequest.Email, confirmationToken(userID, time.Now()))
+	respondWithJSON(w, r, http.StatusOK, map[string]string{"message": "Confirmation email sent"})
+}
+
+// MarkEmailConfirmed confirms, without a token, the email of every user
+// registered with it. It is for the boot self-test's own identity, whose
+// address can't receive mail.
+func MarkEmailConfirmed(email string) error {
+	_, err := db.DB.Exec(`UPDATE users SET email_confirmed = true WHERE email = $1 AND deleted_at IS NULL`, storedEmail(email))
+	return err
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// storedEmail returns the value stored in (and looked up from) the users
+// email column. When EMAIL_HASHING is enabled raw emails are never stored:
+// the column holds a keyed hash of the normalized email instead, which still
+// supports exact-match lookups since the same email always hashes the same.
+func storedEmail(email string) string {
+	if !config.App.EmailHashing {
+		return email
+	}
+
+	mac := hmac.New(sha256.New, []byte(config.App.EmailHashSecret))
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// ReserveEmail holds an email for EMAIL_RESERVATION_TTL, so a multi-step
+// signup can't lose it to a concurrent one after capturing the photo. The
+// returned token must be sent along with the final registration.
+func ReserveEmail(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.ReserveEmailPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Email == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+
+	orgID := callerOrgID(r)
+	email := storedEmail(thisRequest.Email)
+
+	var exists bool
+	query := `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND org_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL)`
+	if err := db.DB.QueryRow(query, email, orgID).Scan(&exists); err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		respondWithError(w, r, codeEmailExists, "Email already exists", http.StatusConflict)
+		return
+	}
+
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		respondWithInternalError(w, r, codeInternalError, "Error generating reservation token", err, http.StatusInternalServerError)
+		return
+	}
+	reservationToken := hex.EncodeToString(token)
+	expiresAt := time.Now().Add(config.App.EmailReservationTTL)
+
+	// Takes over the reservation only once it has expired, atomically
+	query = `
+		INSERT INTO email_reservations (org_id, email, token_hash, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT ((COALESCE(org_id, 0)), email) DO UPDATE
+			SET token_hash = EXCLUDED.token_hash, expires_at = EXCLUDED.expires_at
+			WHERE email_reservations.expires_at <= now()
+		RETURNING id`
+	var reservationID int
+	err := db.DB.QueryRow(query, orgID, email, hashReservationToken(reservationToken), expiresAt).Scan(&reservationID)
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeEmailReserved, "Email is already reserved by another signup", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to reserve email", err, http.StatusInternalServerError)
+		return
+	}
+
+	purgeExpiredReservations(r)
+
+	respondWithJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"reservation_token": reservationToken,
+		"expires_at":        expiresAt.UTC(),
+	})
+}
+
+// checkEmailReservation reports whether the registration may use email: it
+// may when nobody holds an active reservation for it, or when token is the
+// reservation's.
+func checkEmailReservation(r *http.Request, email string, token string) (bool, error) {
+	query := `
+		SELECT token_hash
+		FROM email_reservations
+		WHERE email = $1 AND org_id IS NOT DISTINCT FROM $2 AND expires_at > now()`
+	var tokenHash string
+	err := db.DB.QueryRow(query, storedEmail(email), callerOrgID(r)).Scan(&tokenHash)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hashReservationToken(token))) == 1, nil
+}
+
+// releaseEmailReservation drops the reservation of a registered email.
+func releaseEmailReservation(r *http.Request, email string) {
+	query := `DELETE FROM email_reservations WHERE email = $1 AND org_id IS NOT DISTINCT FROM $2`
+	if _, err := db.DB.Exec(query, storedEmail(email), callerOrgID(r)); err != nil {
+		log.Printf("request_id=%s: failed to release email reservation: %v", middleware.GetRequestID(r.Context()), err)
+	}
+}
+
+// purgeExpiredReservations keeps the table from growing with abandoned
+// signups.
+func purgeExpiredReservations(r *http.Request) {
+	if _, err := db.DB.Exec(`DELETE FROM email_reservations WHERE expires_at <= now()`); err != nil {
+		log.Printf("request_id=%s: failed to purge expired email reservations: %v", middleware.GetRequestID(r.Context()), err)
+	}
+}
+
+func hashReservationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
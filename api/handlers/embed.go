@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// Embed returns the face embedding of a live capture, for integrators doing
+// their own matching instead of enrolling users with us. The capture goes
+// through the same checks as an enrollment photo, anti-spoofing included.
+// Nothing is stored. Embeddings are biometric data, so this is off unless
+// EMBEDDINGS_ENABLED is set.
+func Embed(w http.ResponseWriter, r *http.Request) {
+	summary, w := startSummary(w, r, "embed")
+	defer summary.log()
+
+	if !config.App.EmbeddingsEnabled {
+		respondWithError(w, r, codeForbidden, "Embeddings are disabled", http.StatusForbidden)
+		return
+	}
+
+	var thisRequest models.EmbedPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.EncodedImage == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if isImageTooLarge(thisRequest.EncodedImage) {
+		respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+			map[string]interface{}{"max_chars": config.App.MaxImageChars})
+		return
+	}
+
+	_, forwarded, scale, err := prepareForService(thisRequest.EncodedImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+
+	if detection := checkEnrollmentFace(w, r, summary, callerOrgID(r), forwarded, scale); detection == nil {
+		return
+	}
+
+	embedding, err := microservice.Service.Embed(r.Context(), forwarded)
+	if err != nil {
+		respondWithFaceServiceError(w, r, err)
+		return
+	}
+
+	if !config.App.IncludeVersions {
+		embedding.ModelVersion = ""
+	}
+	summary.result = resultEmbedded
+	respondWithJSON(w, r, http.StatusOK, embedding)
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// checkEnrollmentFace makes sure an enrollment image contains exactly one
+// real, frontal face that is large enough, by the thresholds of the user's
+// organization orgID. forwarded is the image as prepareForService returned
+// it for the microservice, downscaled by scale. On failure it responds and
+// returns nil.
+func checkEnrollmentFace(w http.ResponseWriter, r *http.Request, summary *requestSummary, orgID *int, forwarded string, scale float64) *microservice.DetectionResponse {
+	detection, err := microservice.Service.DetectFace(r.Context(), forwarded, config.App.MinFaceFraction)
+	var statusErr *microservice.StatusError
+	if errors.As(err, &statusErr) {
+		if detail := statusErr.Detail(); detail.Reason == microservice.ReasonFaceTooSmall {
+			respondWithFaceTooSmall(w, r, originalSize(detail.FaceSize, scale), originalSize(detail.ImageSize, scale))
+			return nil
+		}
+	}
+	if err != nil {
+		respondWithFaceServiceError(w, r, err)
+		return nil
+	}
+
+	detection.FaceSize = originalSize(detection.FaceSize, scale)
+	detection.ImageSize = originalSize(detection.ImageSize, scale)
+	if isFaceTooSmall(detection.FaceSize) {
+		respondWithFaceTooSmall(w, r, detection.FaceSize, detection.ImageSize)
+		return nil
+	}
+
+	// Profile or tilted photos make poor references
+	if pose := detection.HeadPose; pose != nil && !isFrontal(pose) {
+		respondWithErrorFields(w, r, codeFaceNotFrontal,
+			fmt.Sprintf("Face is not frontal (yaw %.0f°, pitch %.0f°, roll %.0f°). Please look straight at the camera", pose.Yaw, pose.Pitch, pose.Roll),
+			http.StatusUnprocessableEntity, map[string]interface{}{"head_pose": pose})
+		return nil
+	}
+
+	if detection.AntiSScore == nil || detection.IsReal == nil {
+		if !allowMissingAntiSpoof(r, "enrollment") {
+			respondWithError(w, r, codeAntiSpoofNotEvaluated, "Anti-spoofing could not be evaluated", http.StatusBadGateway)
+			return nil
+		}
+		return detection
+	}
+
+	if *detection.AntiSScore < thresholds.ForOrg(orgID).AntiSpoofMin {
+		summary.result = resultSpoofRejected
+		respondWithError(w, r, codeSpoofDetected, "Spoof detected. Please use a live camera capture", http.StatusUnprocessableEntity)
+		return nil
+	}
+
+	return detection
+}
+
+// allowMissingAntiSpoof applies ANTISPOOF_MISSING_POLICY to a face service
+// response without an anti-spoofing verdict. Either way it is logged, as it
+// means an outdated model is deployed.
+func allowMissingAntiSpoof(r *http.Request, step string) bool {
+	allow := config.App.AntiSpoofMissingPolicy == config.AntiSpoofAllow
+	log.Printf("request_id=%s: face service returned no anti-spoofing verdict for %s, policy %q",
+		middleware.GetRequestID(r.Context()), step, config.App.AntiSpoofMissingPolicy)
+	return allow
+}
+
+// isFaceTooSmall reports whether a detected face is narrower or shorter than
+// MIN_FACE_PIXELS. Faces of unknown size pass.
+func isFaceTooSmall(face *microservice.Size) bool {
+	return face != nil && min(face.Width, face.Height) < config.App.MinFacePixels
+}
+
+// respondWithFaceTooSmall asks the user to move closer, with the measured
+// sizes when known.
+func respondWithFaceTooSmall(w http.ResponseWriter, r *http.Request, face, image *microservice.Size) {
+	fields := map[string]interface{}{}
+	if face != nil {
+		fields["face_size"] = face
+	}
+	if image != nil {
+		fields["image_size"] = image
+	}
+	respondWithErrorFields(w, r, codeFaceTooSmall, "Face is too small. Please move closer to the camera", http.StatusUnprocessableEntity, fields)
+}
+
+// isFrontal reports whether pose is within the configured angle tolerance.
+// A tilted head (roll) makes as poor a reference as a turned one, so it is
+// checked along with yaw and pitch.
+func isFrontal(pose *microservice.HeadPose) bool {
+	limit := config.App.FrontalMaxAngle
+	if limit <= 0 {
+		return true
+	}
+	return poseAngle(pose) <= limit
+}
+
+// poseAngle returns the largest rotation of pose, in degrees.
+func poseAngle(pose *microservice.HeadPose) float64 {
+	return math.Max(math.Abs(pose.Yaw), math.Max(math.Abs(pose.Pitch), math.Abs(pose.Roll)))
+}
+
+// referenceFolder is the Cloudinary folder of the reference images of an
+// organization's users, so inline references can be held to the caller's
+// organization. A nil orgID is for users registered without an API key.
+func referenceFolder(orgID *int) string {
+	if orgID == nil {
+		return "references/no_org"
+	}
+	return fmt.Sprintf("references/org_%d", *orgID)
+}
+
+// referencePublicID is the Cloudinary public ID of a user's reference image
+// when it is uploaded in place.
+func referencePublicID(orgID *int, userID int) string {
+	return fmt.Sprintf("%s/user_%d", referenceFolder(orgID), userID)
+}
+
+var urlVersion = regexp.MustCompile(`/v\d+/`)
+
+// stableImageURL drops the version segment from a Cloudinary delivery URL.
+// Without it the URL always serves the latest version of the asset, so it
+// stays the same when the image is overwritten.
+func stableImageURL(secureURL string) string {
+	return urlVersion.ReplaceAllString(secureURL, "/")
+}
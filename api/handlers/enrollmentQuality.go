@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"math"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/imageproc"
+	"github.com/kwagmire/facial-verification-api/microservice"
+)
+
+// Signal values earning full marks in the enrollment quality score. A face
+// of goodFacePixels fills the input of the larger recognition models,
+// goodContrast is a well exposed portrait, and heads turned
+// worstPoseAngle or more get no frontality marks.
+const (
+	goodFacePixels = 224
+	goodContrast   = 64
+	worstPoseAngle = 45
+)
+
+// enrollmentQuality combines the detection signals of a reference image
+// into a 0-100 score, weighted by ENROLLMENT_QUALITY_WEIGHT_*. Signals the
+// face service didn't report are left out rather than counted as zero.
+// Returns nil when none is available.
+func enrollmentQuality(detection *microservice.DetectionResponse, image string) *int {
+	var total, weights float64
+	add := func(weight, value float64) {
+		if weight <= 0 {
+			return
+		}
+		total += weight * math.Max(0, math.Min(1, value))
+		weights += weight
+	}
+
+	if detection.AntiSScore != nil {
+		add(config.App.QualityWeightAntiSpoof, *detection.AntiSScore)
+	}
+	if face := detection.FaceSize; face != nil {
+		add(config.App.QualityWeightFaceSize, float64(min(face.Width, face.Height))/goodFacePixels)
+	}
+	if pose := detection.HeadPose; pose != nil {
+		add(config.App.QualityWeightFrontality, 1-poseAngle(pose)/worstPoseAngle)
+	}
+	if contrast, err := imageproc.Contrast(image); err == nil {
+		add(config.App.QualityWeightContrast, contrast/goodContrast)
+	}
+
+	if weights == 0 {
+		return nil
+	}
+	score := int(math.Round(100 * total / weights))
+	return &score
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/metrics"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// verifyWithEnsemble runs the verification on the primary service and, when
+// configured, concurrently on the ensemble's alternate model. The second
+// result is nil without an ensemble, or when the alternate model didn't
+// answer within ENSEMBLE_TIMEOUT.
+func verifyWithEnsemble(ctx context.Context, request microservice.VerifyRequest) (*microservice.VerificationResponse, *microservice.VerificationResponse, error) {
+	if microservice.Ensemble == nil {
+		primary, err := timedVerify(ctx, microservice.Service, "primary", request)
+		return primary, nil, err
+	}
+
+	type result struct {
+		resp *microservice.VerificationResponse
+		err  error
+	}
+	alternate := make(chan result, 1)
+	go func() {
+		// The primary's anti-spoofing verdict is enough
+		ensembleRequest := request
+		ensembleRequest.AntiSpoofing = false
+		ensembleCtx, cancel := ctx, context.CancelFunc(func() {})
+		if config.App.EnsembleTimeout > 0 {
+			ensembleCtx, cancel = context.WithTimeout(ctx, config.App.EnsembleTimeout)
+		}
+		defer cancel()
+
+		resp, err := timedVerify(ensembleCtx, microservice.Ensemble, "ensemble", ensembleRequest)
+		alternate <- result{resp, err}
+	}()
+
+	primary, err := timedVerify(ctx, microservice.Service, "primary", request)
+	second := <-alternate
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only the ensemble timing out falls back, other failures still fail
+	if second.err != nil && isTimeout(second.err) && ctx.Err() == nil {
+		metrics.EnsembleFallbacks.Inc()
+		log.Printf("request_id=%s: ensemble model timed out, using the primary model's verdict: %v", middleware.GetRequestID(ctx), second.err)
+		return primary, nil, nil
+	}
+	if second.err != nil {
+		return nil, nil, second.err
+	}
+	return primary, second.resp, nil
+}
+
+// isTimeout reports whether err is a deadline passing, either of the context
+// or of the HTTP client (http.Client.Timeout surfaces as a url.Error).
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// timedVerify calls client.Verify and records its duration under model.
+func timedVerify(ctx context.Context, client microservice.FaceService, model string, request microservice.VerifyRequest) (*microservice.VerificationResponse, error) {
+	start := time.Now()
+	resp, err := client.Verify(ctx, request)
+	metrics.VerifyModelLatency.WithLabelValues(model).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// ensembleThresholds translates limits, set for the primary model, to the
+// alternate one, whose distances are on a scale of their own: its threshold
+// is moved by the same ratio as the runtime match threshold moves the
+// primary model's.
+func ensembleThresholds(limits thresholds.Thresholds, primary, alternate *microservice.VerificationResponse) thresholds.Thresholds {
+	if limits.MatchThreshold > 0 && primary.Threshold > 0 {
+		limits.MatchThreshold = alternate.Threshold * limits.MatchThreshold / primary.Threshold
+	}
+	return limits
+}
+
+// combineVerdicts returns the ensemble's match decision according to mode.
+// Distances of different models aren't on the same scale, so "average"
+// compares each distance relative to its own model's threshold.
+func combineVerdicts(primary, alternate *microservice.VerificationResponse, mode string) bool {
+	switch mode {
+	case config.EnsembleAny:
+		return primary.IsMatch || alternate.IsMatch
+	case config.EnsembleAverage:
+		relative := (primary.Distance/primary.Threshold + alternate.Distance/alternate.Threshold) / 2
+		return relative <= 1
+	default:
+		return primary.IsMatch && alternate.IsMatch
+	}
+}
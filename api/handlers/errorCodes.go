@@ -0,0 +1,53 @@
+package handlers
+
+// Machine-readable error codes returned alongside every error message.
+// These are part of the API contract: clients branch on them, so they
+// must never change once published.
+const (
+	codeMethodNotAllowed       = "METHOD_NOT_ALLOWED"
+	codeInvalidRequest         = "INVALID_REQUEST"
+	codeTrailingData           = "TRAILING_DATA"
+	codeMissingFields          = "MISSING_FIELDS"
+	codeInvalidImage           = "INVALID_IMAGE"
+	codeImageRejected          = "IMAGE_REJECTED"
+	codeImageTooDark           = "IMAGE_TOO_DARK"
+	codeLowContrast            = "LOW_CONTRAST"
+	codeGrayscaleImage         = "GRAYSCALE_IMAGE"
+	codeImageTooLarge          = "IMAGE_TOO_LARGE"
+	codeEmailExists            = "EMAIL_EXISTS"
+	codeEmailReserved          = "EMAIL_RESERVED"
+	codeDuplicateName          = "DUPLICATE_NAME"
+	codeUserNotFound           = "USER_NOT_FOUND"
+	codeInternalError          = "INTERNAL_ERROR"
+	codeRequestTimeout         = "REQUEST_TIMEOUT"
+	codeDatabaseError          = "DATABASE_ERROR"
+	codeFaceServiceError       = "FACE_SERVICE_ERROR"
+	codeReferenceUnavailable   = "REFERENCE_UNAVAILABLE"
+	codeImageUploadFailed      = "IMAGE_UPLOAD_FAILED"
+	codeDailyLimitReached      = "DAILY_LIMIT_REACHED"
+	codeUserQuotaExceeded      = "USER_QUOTA_EXCEEDED"
+	codeTooManyConcurrent      = "TOO_MANY_CONCURRENT_REQUESTS"
+	codeVerificationInProgress = "VERIFICATION_IN_PROGRESS"
+	codeMaintenance            = "MAINTENANCE"
+	codeSpoofDetected          = "SPOOF_DETECTED"
+	codeAntiSpoofNotEvaluated  = "ANTISPOOF_NOT_EVALUATED"
+	codeFaceNotFrontal         = "FACE_NOT_FRONTAL"
+	codeFaceTooSmall           = "FACE_TOO_SMALL"
+	codeStaleEnrollment        = "STALE_ENROLLMENT"
+	codeNoEnrollmentImage      = "NO_ENROLLMENT_IMAGE"
+	codeNoFaceInDocument       = "NO_FACE_IN_DOCUMENT"
+	codeReplayDetected         = "REPLAY_DETECTED"
+	codeEmailNotConfirmed      = "EMAIL_NOT_CONFIRMED"
+	codeInvalidToken           = "INVALID_TOKEN"
+	codeEmailAlreadyConfirmed  = "EMAIL_ALREADY_CONFIRMED"
+
+	codeUnexpectedUpstream = "UNEXPECTED_UPSTREAM_RESPONSE"
+
+	codeUnauthorized = "UNAUTHORIZED"
+	codeForbidden    = "FORBIDDEN"
+
+	codeOrganizationExists   = "ORGANIZATION_EXISTS"
+	codeOrganizationNotFound = "ORGANIZATION_NOT_FOUND"
+
+	codeRegistrationNotFound = "REGISTRATION_NOT_FOUND"
+)
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// ExportUser returns everything held about a user, for data subject access
+// requests.
+func ExportUser(w http.ResponseWriter, r *http.Request) {
+	orgID, err := requestedOrgID(r)
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	user, err := fetchUser(orgID, r.PathValue("email"))
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	attempts, err := fetchVerificationAttempts(user.ID)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	user.ImageURL, err = signedImageURL(user)
+	if err != nil {
+		respondWithInternalError(w, r, codeInternalError, "Error signing image URL", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, models.UserExport{
+		Profile:              user.UserProfile,
+		VerificationAttempts: attempts,
+		ExportedAt:           time.Now().UTC(),
+	})
+}
+
+// signedImageURL returns a signed Cloudinary URL for the user's registration
+// image. Users registered before public IDs were stored fall back to the
+// plain stored URL.
+func signedImageURL(user *userRecord) (string, error) {
+	if !user.RegImagePublicID.Valid {
+		return user.RegImageURL, nil
+	}
+
+	return imagestore.Store.SignedURL(user.RegImagePublicID.String)
+}
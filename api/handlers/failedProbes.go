@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// storeFailedProbe uploads the probe of a failed verification for later
+// investigation and returns its public ID, or nil when probes aren't stored.
+// Probes are uploaded as authenticated assets, so they can't be fetched
+// without a signed URL.
+func storeFailedProbe(r *http.Request, probe string) *string {
+	if !config.App.StoreFailedProbes || probe == "" {
+		return nil
+	}
+
+	uploadResult, err := imagestore.Store.Upload(context.Background(), probe, uploader.UploadParams{
+		Folder: config.App.FailedProbeFolder,
+		Type:   api.Authenticated,
+	})
+	if err != nil {
+		log.Printf("request_id=%s: failed to store probe: %v", middleware.GetRequestID(r.Context()), err)
+		return nil
+	}
+	return &uploadResult.PublicID
+}
+
+// StartRetention periodically deletes stored probes older than
+// FAILED_PROBE_RETENTION and failed registrations older than
+// DEAD_LETTER_RETENTION, until ctx is cancelled. It runs even when storing
+// them is off, so those kept before it was turned off still expire.
+func StartRetention(ctx context.Context) {
+	goBackground(func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			purgeExpiredProbes(ctx)
+			purgeExpiredRegistrations(ctx)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	})
+}
+
+func purgeExpiredProbes(ctx context.Context) {
+	query := `
+		SELECT id, probe_public_id
+		FROM verification_attempts
+		WHERE probe_public_id IS NOT NULL AND created_at < now() - $1 * interval '1 second'`
+	rows, err := db.DB.QueryContext(ctx, query, config.App.FailedProbeRetention.Seconds())
+	if err != nil {
+		log.Printf("Failed to list expired probes: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	expired := map[int]string{}
+	for rows.Next() {
+		var attemptID int
+		var publicID string
+		if err := rows.Scan(&attemptID, &publicID); err != nil {
+			log.Printf("Failed to list expired probes: %v", err)
+			return
+		}
+		expired[attemptID] = publicID
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list expired probes: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+
+	for attemptID, publicID := range expired {
+		if ctx.Err() != nil {
+			return
+		}
+		_, err := imagestore.Store.Destroy(ctx, uploader.DestroyParams{
+			PublicID:   publicID,
+			Type:       api.Authenticated,
+			Invalidate: api.Bool(true),
+		})
+		if err != nil {
+			log.Printf("Failed to delete expired probe %s: %v", publicID, err)
+			continue
+		}
+
+		_, err = db.DB.Exec(`UPDATE verification_attempts SET probe_public_id = NULL WHERE id = $1`, attemptID)
+		if err != nil {
+			log.Printf("Failed to unlink expired probe %s: %v", publicID, err)
+		}
+	}
+	log.Printf("Purged %d expired probes.", len(expired))
+}
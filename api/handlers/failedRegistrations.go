@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// saveFailedRegistration keeps a registration that passed the face checks
+// but failed on upload or insert, so it can be retried without asking the
+// user to capture again. Only done when DEAD_LETTER_REGISTRATIONS is on.
+func saveFailedRegistration(r *http.Request, u newUser, image string, cause error) {
+	if !config.App.DeadLetterRegistrations {
+		return
+	}
+
+	query := `
+		INSERT INTO failed_registrations (org_id, email, first_name, last_name, image, antispoof_score, enrollment_quality, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err := db.DB.Exec(query, u.orgID, u.email, u.firstName, u.lastName, image, u.antiSpoofScore, u.quality, cause.Error())
+	if err != nil {
+		log.Printf("request_id=%s: failed to save failed registration: %v", middleware.GetRequestID(r.Context()), err)
+	}
+}
+
+type failedRegistration struct {
+	ID        int       `json:"id"`
+	OrgID     *int      `json:"org_id,omitempty"`
+	Email     string    `json:"email"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ListFailedRegistrations returns the registrations waiting for a retry,
+// oldest first. Images are left out.
+func ListFailedRegistrations(w http.ResponseWriter, r *http.Request) {
+	rows, err := db.DB.Query(`SELECT id, org_id, email, error, created_at FROM failed_registrations ORDER BY id`)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	failed := []failedRegistration{}
+	for rows.Next() {
+		var f failedRegistration
+		if err := rows.Scan(&f.ID, &f.OrgID, &f.Email, &f.Error, &f.CreatedAt); err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		failed = append(failed, f)
+	}
+	if err := rows.Err(); err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{"failed_registrations": failed})
+}
+
+// RetryFailedRegistration replays the upload and insert of a failed
+// registration. The face checks aren't run again as the image already
+// passed them. The entry is removed once the user is registered, or when
+// the email or name has been taken since.
+func RetryFailedRegistration(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid registration ID", http.StatusBadRequest)
+		return
+	}
+
+	var user newUser
+	var image string
+	query := `
+		SELECT org_id, email, first_name, last_name, image, antispoof_score, enrollment_quality
+		FROM failed_registrations
+		WHERE id = $1`
+	err = db.DB.QueryRow(query, id).Scan(&user.orgID, &user.email, &user.firstName, &user.lastName, &image, &user.antiSpoofScore, &user.quality)
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeRegistrationNotFound, "Failed registration not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	ctx := context.Background()
+
+	uploadResult, err := imagestore.Store.Upload(ctx, image, uploader.UploadParams{Folder: referenceFolder(user.orgID)})
+	if err == nil {
+		err = imagestore.Store.CheckURL(uploadResult.SecureURL)
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeImageUploadFailed, "Error uploading image to Cloudinary", err, http.StatusInternalServerError)
+		return
+	}
+
+	user.imageURL, user.publicID = uploadResult.SecureURL, uploadResult.PublicID
+	userID, enrollmentID, err := insertUser(user)
+	if err != nil {
+		if _, err := imagestore.Store.Destroy(ctx, uploader.DestroyParams{PublicID: uploadResult.PublicID, Invalidate: api.Bool(true)}); err != nil {
+			log.Printf("request_id=%s: failed to delete orphaned upload %s: %v", middleware.GetRequestID(r.Context()), uploadResult.PublicID, err)
+		}
+		if isUniqueViolation(err) {
+			deleteFailedRegistration(r, id)
+		}
+		respondWithUserInsertError(w, r, err)
+		return
+	}
+
+	deleteFailedRegistration(r, id)
+
+	// The plain email is only known when it isn't hashed
+	if config.App.RequireEmailConfirmation {
+		if config.App.EmailHashing {
+			log.Printf("request_id=%s: no confirmation email sent for recovered user %d, emails are hashed", middleware.GetRequestID(r.Context()), userID)
+		} else {
+			sendConfirmationEmail(r, user.email, confirmationToken(userID, time.Now()))
+		}
+	}
+
+	respondWithJSON(w, r, http.StatusCreated, map[string]interface{}{
+		"message":       "Registration recovered",
+		"enrollment_id": enrollmentID,
+	})
+}
+
+func deleteFailedRegistration(r *http.Request, id int) {
+	if _, err := db.DB.Exec(`DELETE FROM failed_registrations WHERE id = $1`, id); err != nil {
+		log.Printf("request_id=%s: failed to delete failed registration %d: %v", middleware.GetRequestID(r.Context()), id, err)
+	}
+}
+
+// purgeExpiredRegistrations deletes failed registrations older than
+// DEAD_LETTER_RETENTION, as they hold biometric images.
+func purgeExpiredRegistrations(ctx context.Context) {
+	query := `DELETE FROM failed_registrations WHERE created_at < now() - $1 * interval '1 second'`
+	result, err := db.DB.ExecContext(ctx, query, config.App.DeadLetterRetention.Seconds())
+	if err != nil {
+		log.Printf("Failed to purge expired failed registrations: %v", err)
+		return
+	}
+	if purged, _ := result.RowsAffected(); purged > 0 {
+		log.Printf("Purged %d expired failed registrations.", purged)
+	}
+}
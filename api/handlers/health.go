@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// activeVerifications mirrors the active_verifications gauge, which can't
+// be read back.
+var activeVerifications atomic.Int64
+
+// Health reports that the API is up, along with its current load.
+func Health(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{
+		"status":               "ok",
+		"active_verifications": activeVerifications.Load(),
+		"maintenance":          maintenanceMode.Load(),
+	})
+}
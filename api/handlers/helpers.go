@@ -1,22 +1,268 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/imageproc"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
 )
 
-func respondWithJSON(w http.ResponseWriter, status int, payload interface{}) {
-	response, err := json.Marshal(payload)
+// respondWithJSON writes payload as the response. Objects get the server
+// time added as "timestamp" (RFC 3339), for clients to correlate their logs
+// with ours and spot clock skew. Arrays, only returned by admin listings
+// (distance history, API keys), are left without one rather than breaking
+// their shape. Clients accepting application/msgpack get the same response
+// msgpack-encoded.
+func respondWithJSON(w http.ResponseWriter, r *http.Request, status int, payload interface{}) {
+	var response []byte
+	var err error
+	contentType := "application/json"
+	if wantsMsgpack(r) {
+		response, err = marshalMsgpack(payload, time.Now())
+		contentType = msgpackContentType
+	} else {
+		response, err = json.Marshal(payload)
+		response = withTimestamp(response, time.Now())
+	}
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	if config.App.MsgpackResponses {
+		w.Header().Add("Vary", "Accept")
+	}
 
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(status)
 	w.Write(response)
 }
 
-func respondWithError(w http.ResponseWriter, message string, status int) {
-	respondWithJSON(w, status, map[string]string{"error": message})
+// respondWithError sends an error response. message is the English text; it
+// is replaced by its translation for code when the client's Accept-Language
+// asks for a supported language. The code itself is never translated.
+func respondWithError(w http.ResponseWriter, r *http.Request, code string, message string, status int) {
+	respondWithErrorFields(w, r, code, message, status, nil)
+}
+
+// respondWithErrorFields is respondWithError with extra fields added to the
+// response body, e.g. measurements the client can use to guide the user.
+func respondWithErrorFields(w http.ResponseWriter, r *http.Request, code string, message string, status int, fields map[string]interface{}) {
+	message, lang := localizedMessage(r, code, message)
+	writeError(w, r, code, message, lang.String(), status, fields)
+}
+
+// respondWithInternalError logs err together with the request ID and only
+// exposes its detail to the client when DETAILED_ERRORS is enabled.
+func respondWithInternalError(w http.ResponseWriter, r *http.Request, code string, message string, err error, status int) {
+	if requestCancelled(r, err) {
+		respondCancelled(w, r, code, err)
+		return
+	}
+
+	log.Printf("request_id=%s code=%s: %s: %v", middleware.GetRequestID(r.Context()), code, message, err)
+
+	message, lang := localizedMessage(r, code, message)
+	if config.App.DetailedErrors {
+		message += ": " + err.Error()
+	}
+	writeError(w, r, code, message, lang.String(), status, nil)
+}
+
+// statusClientClosedRequest is nginx's non-standard status for a request
+// the client gave up on before getting a response.
+const statusClientClosedRequest = 499
+
+// requestCancelled reports whether err comes from the client disconnecting,
+// which cancels r's context, rather than from something failing on our
+// side. A deadline passing, including WithTimeout's own, is a failure of
+// ours and doesn't count.
+func requestCancelled(r *http.Request, err error) bool {
+	return errors.Is(r.Context().Err(), context.Canceled) && errors.Is(err, context.Canceled)
+}
+
+// respondCancelled ends a request whose client is gone. Nobody reads the
+// response, the 499 is only written so the request summary and metrics
+// don't count it as a server error. Logged at debug level for the same
+// reason.
+func respondCancelled(w http.ResponseWriter, r *http.Request, code string, err error) {
+	middleware.Logger(r.Context()).Debug("request cancelled", "code", code, "error", err.Error())
+	w.WriteHeader(statusClientClosedRequest)
+}
+
+// respondWithFaceServiceError reports a failed call to the face microservice.
+func respondWithFaceServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	var contractErr *microservice.ContractError
+	if errors.As(err, &contractErr) {
+		respondWithInternalError(w, r, codeUnexpectedUpstream, "Unexpected upstream response", err, http.StatusBadGateway)
+		return
+	}
+
+	// The microservice answers 400 (or 422 for a malformed payload) when the
+	// image is the problem. The client gets a 422 with the reason only, as
+	// the raw detail may echo internals. Anything else is our side failing.
+	var statusErr *microservice.StatusError
+	if errors.As(err, &statusErr) {
+		// Known error codes get a clean error of ours, the raw detail is
+		// only logged
+		if code, message, status, ok := faceServiceErrorCode(statusErr); ok {
+			log.Printf("request_id=%s code=%s: %v", middleware.GetRequestID(r.Context()), code, err)
+			respondWithError(w, r, code, message, status)
+			return
+		}
+		if statusErr.StatusCode == http.StatusBadRequest || statusErr.StatusCode == http.StatusUnprocessableEntity {
+			log.Printf("request_id=%s code=%s: %v", middleware.GetRequestID(r.Context()), codeImageRejected, err)
+			respondWithErrorFields(w, r, codeImageRejected, "The image could not be processed", http.StatusUnprocessableEntity,
+				map[string]interface{}{"reason": rejectionReason(statusErr)})
+			return
+		}
+		respondWithInternalError(w, r, codeFaceServiceError, "Face service returned an error", err, http.StatusBadGateway)
+		return
+	}
+	// Unreachable or dropped the connection: the upstream failed, not us
+	respondWithInternalError(w, r, codeFaceServiceError, "Face service unavailable", err, http.StatusBadGateway)
+}
+
+// faceServiceErrorCode maps the error code of a microservice error to ours.
+func faceServiceErrorCode(err *microservice.StatusError) (code, message string, status int, ok bool) {
+	switch err.ErrorCode() {
+	case microservice.ErrorCodeReferenceUnavailable:
+		return codeReferenceUnavailable, "The reference image could not be fetched", http.StatusBadGateway, true
+	case microservice.ErrorCodeInvalidImage:
+		return codeInvalidImage, "Invalid Base64 image", http.StatusBadRequest, true
+	case microservice.ErrorCodeInternal:
+		return codeFaceServiceError, "Face service returned an error", http.StatusBadGateway, true
+	}
+	return "", "", 0, false
+}
+
+// rejectionReason returns the microservice's reason for rejecting an image
+// when it is one clients know about, and a generic one otherwise.
+func rejectionReason(err *microservice.StatusError) string {
+	switch reason := err.Reason(); reason {
+	case microservice.ReasonNoFace, microservice.ReasonNoFaceInReference, microservice.ReasonLowQuality, microservice.ReasonNoFaceDetected,
+		microservice.ReasonMultipleFaces, microservice.ReasonFaceTooSmall:
+		return reason
+	}
+	return reasonUnprocessableImage
+}
+
+// isImageTooLarge reports whether a Base64 image is longer than
+// MAX_IMAGE_CHARS, when it is set.
+func isImageTooLarge(image string) bool {
+	return config.App.MaxImageChars > 0 && len(image) > config.App.MaxImageChars
+}
+
+// respondWithImageError reports an image that failed preprocessing.
+func respondWithImageError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, imageproc.ErrInvalidImage) {
+		respondWithError(w, r, codeInvalidImage, "Invalid Base64 image", http.StatusBadRequest)
+		return
+	}
+
+	var qualityErr *imageproc.QualityError
+	if errors.As(err, &qualityErr) {
+		code, message := codeLowContrast, "Image contrast is too low. Please retake the photo in better light"
+		switch qualityErr.Problem {
+		case imageproc.ProblemTooDark:
+			code, message = codeImageTooDark, "Image is too dark. Please retake the photo in better light"
+		case imageproc.ProblemGrayscale:
+			code, message = codeGrayscaleImage, "Image is black and white. Please retake the photo in color"
+		}
+		respondWithErrorFields(w, r, code, message, http.StatusUnprocessableEntity, map[string]interface{}{
+			"brightness": math.Round(qualityErr.Brightness*10) / 10,
+			"contrast":   math.Round(qualityErr.Contrast*10) / 10,
+		})
+		return
+	}
+	respondWithInternalError(w, r, codeInternalError, "Error processing image", err, http.StatusInternalServerError)
+}
+
+// withTimestamp adds a "timestamp" field to a marshalled JSON object. Other
+// values, such as arrays, are returned as they are.
+func withTimestamp(response []byte, now time.Time) []byte {
+	if len(response) < 2 || response[0] != '{' {
+		return response
+	}
+
+	field := `"timestamp":"` + now.UTC().Format(time.RFC3339) + `"`
+	if string(response) == "{}" {
+		return []byte("{" + field + "}")
+	}
+	return append([]byte("{"+field+","), response[1:]...)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, code string, message string, lang string, status int, fields map[string]interface{}) {
+	body := map[string]interface{}{"error": message, "code": code}
+	for key, value := range fields {
+		body[key] = value
+	}
+
+	w.Header().Set("Content-Language", lang)
+	respondWithJSON(w, r, status, body)
+}
+
+// decodeJSONBody decodes the request body into dst. On failure it responds
+// with a 400 and returns false. When DETAILED_ERRORS is enabled the response
+// pinpoints the problem (byte offset of a syntax error, mistyped field...).
+// With STRICT_JSON_BODY, anything after the first JSON value is a 400
+// TRAILING_DATA rather than being silently ignored.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(dst)
+	if err == nil {
+		if config.App.StrictJSONBody {
+			if _, err := dec.Token(); err != io.EOF {
+				var fields map[string]interface{}
+				if config.App.DetailedErrors {
+					fields = map[string]interface{}{"detail": fmt.Sprintf("unexpected data at byte offset %d", dec.InputOffset())}
+				}
+				respondWithErrorFields(w, r, codeTrailingData, "Unexpected data after the JSON body", http.StatusBadRequest, fields)
+				return false
+			}
+		}
+		return true
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var detail string
+	switch {
+	case errors.Is(err, io.EOF):
+		detail = "request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		detail = "request body ended unexpectedly"
+	case errors.As(err, &syntaxErr):
+		detail = fmt.Sprintf("malformed JSON at byte offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		detail = fmt.Sprintf("field %q must be of type %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		detail = err.Error()
+	}
+
+	var fields map[string]interface{}
+	if config.App.DetailedErrors {
+		fields = map[string]interface{}{"detail": detail}
+	}
+	respondWithErrorFields(w, r, codeInvalidRequest, "Invalid request payload", http.StatusBadRequest, fields)
+	return false
+}
+
+// clientIP returns the IP address of the client that sent r.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
 }
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+)
+
+// verifyInlineReference handles a verify request carrying reg_image_url, from
+// clients keeping their own cache of reference URLs. It skips the user
+// lookup and with it everything tied to the user: email confirmation,
+// quota, replay guard and attempt history. Hence ALLOW_INLINE_REFERENCE.
+// Only reference images of the caller's organization, in our own image
+// store, are accepted.
+func verifyInlineReference(w http.ResponseWriter, r *http.Request, summary *requestSummary, referenceURL string, frames []string) {
+	if !config.App.AllowInlineReference {
+		respondWithError(w, r, codeInvalidRequest, "reg_image_url is not accepted", http.StatusBadRequest)
+		return
+	}
+	if err := imagestore.Store.CheckURL(referenceURL); err != nil || !isOrgReference(referenceURL, callerOrgID(r)) {
+		respondWithError(w, r, codeInvalidRequest, "reg_image_url is not a reference image of your organization", http.StatusBadRequest)
+		return
+	}
+	if len(frames) != 1 {
+		respondWithError(w, r, codeInvalidRequest, "reg_image_url takes a single facial_image", http.StatusBadRequest)
+		return
+	}
+
+	_, probe, scale, err := prepareForService(frames[0])
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+
+	verifyAgainstReference(w, r, summary, referenceURL, probe, scale, false)
+}
+
+// referencePath matches the path of a reference image delivery URL, with the
+// cloud name first on the shared host, and captures its folder.
+var referencePath = regexp.MustCompile(`^(?:/[^/]+)?/image/upload/(?:v\d+/)?(references/[^/]+)/[^/]+$`)
+
+// isOrgReference reports whether referenceURL is a reference image in the
+// folder of orgID.
+func isOrgReference(referenceURL string, orgID *int) bool {
+	u, err := url.Parse(referenceURL)
+	if err != nil {
+		return false
+	}
+	match := referencePath.FindStringSubmatch(u.Path)
+	return match != nil && match[1] == referenceFolder(orgID)
+}
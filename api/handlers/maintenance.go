@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// maintenanceMode starts out as MAINTENANCE_MODE and can be toggled at
+// runtime through the admin API. The toggle only affects this instance and
+// isn't persisted across restarts.
+var maintenanceMode atomic.Bool
+
+// SetMaintenanceMode turns maintenance mode on or off.
+func SetMaintenanceMode(enabled bool) {
+	if maintenanceMode.Swap(enabled) == enabled {
+		return
+	}
+	if enabled {
+		log.Println("Maintenance mode enabled")
+	} else {
+		log.Println("Maintenance mode disabled")
+	}
+}
+
+// UnlessMaintenance answers with a 503 and a Retry-After while maintenance
+// mode is on, instead of letting requests fail halfway through migrations
+// or incidents. Health and admin routes aren't wrapped and stay live.
+func UnlessMaintenance(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !maintenanceMode.Load() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		retryAfter := int(math.Ceil(config.App.MaintenanceRetryAfter.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		respondWithErrorFields(w, r, codeMaintenance, "The service is down for maintenance, please try again later", http.StatusServiceUnavailable,
+			map[string]interface{}{"retry_after_seconds": retryAfter})
+	})
+}
+
+type maintenancePayload struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// GetMaintenance reports whether maintenance mode is on.
+func GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, map[string]bool{"enabled": maintenanceMode.Load()})
+}
+
+// UpdateMaintenance turns maintenance mode on or off.
+func UpdateMaintenance(w http.ResponseWriter, r *http.Request) {
+	var payload maintenancePayload
+	if !decodeJSONBody(w, r, &payload) {
+		return
+	}
+	if payload.Enabled == nil {
+		respondWithError(w, r, codeMissingFields, "enabled is required", http.StatusBadRequest)
+		return
+	}
+
+	SetMaintenanceMode(*payload.Enabled)
+	respondWithJSON(w, r, http.StatusOK, map[string]bool{"enabled": *payload.Enabled})
+}
@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"net/http"
+
+	"golang.org/x/text/language"
+)
+
+// supportedLanguages lists the languages error messages are available in.
+// English comes first so it is used when nothing better matches.
+var supportedLanguages = []language.Tag{
+	language.English,
+	language.French,
+	language.Spanish,
+}
+
+var languageMatcher = language.NewMatcher(supportedLanguages)
+
+// messages holds the translated error message for each error code, keyed by
+// base language. English messages are written inline by the handlers, so
+// codes missing here (or languages missing entirely) fall back to them.
+var messages = map[string]map[string]string{
+	"fr": {
+		codeMethodNotAllowed:       "Méthode non acceptée",
+		codeInvalidRequest:         "Requête invalide",
+		codeTrailingData:           "Données superflues après le corps JSON",
+		codeMissingFields:          "Tous les champs sont obligatoires",
+		codeEmailExists:            "Cette adresse e-mail existe déjà",
+		codeEmailReserved:          "Cette adresse e-mail est déjà réservée par une autre inscription",
+		codeDuplicateName:          "Un utilisateur portant ce nom existe déjà",
+		codeUserNotFound:           "Ce compte utilisateur n'existe pas",
+		codeInternalError:          "Erreur interne du serveur",
+		codeRequestTimeout:         "La requête a expiré, veuillez réessayer",
+		codeDatabaseError:          "Erreur de base de données",
+		codeFaceServiceError:       "Le service de reconnaissance faciale a rencontré une erreur",
+		codeReferenceUnavailable:   "L'image de référence n'a pas pu être récupérée",
+		codeInvalidImage:           "Image Base64 invalide",
+		codeImageRejected:          "L'image n'a pas pu être traitée",
+		codeImageTooDark:           "L'image est trop sombre. Veuillez reprendre la photo avec un meilleur éclairage",
+		codeLowContrast:            "Le contraste de l'image est trop faible. Veuillez reprendre la photo avec un meilleur éclairage",
+		codeGrayscaleImage:         "L'image est en noir et blanc. Veuillez reprendre la photo en couleur",
+		codeImageTooLarge:          "L'image est trop volumineuse",
+		codeImageUploadFailed:      "Échec de l'envoi de l'image",
+		codeDailyLimitReached:      "Limite quotidienne d'inscriptions atteinte, veuillez réessayer demain",
+		codeUserQuotaExceeded:      "Trop de vérifications pour cet utilisateur, veuillez réessayer plus tard",
+		codeTooManyConcurrent:      "Trop de requêtes simultanées, veuillez attendre la fin des précédentes",
+		codeVerificationInProgress: "Une vérification est déjà en cours pour cet utilisateur",
+		codeMaintenance:            "Service en maintenance, veuillez réessayer plus tard",
+		codeSpoofDetected:          "Usurpation détectée. Veuillez utiliser une capture caméra en direct",
+		codeAntiSpoofNotEvaluated:  "La détection d'usurpation n'a pas pu être effectuée",
+		codeFaceNotFrontal:         "Le visage n'est pas de face. Veuillez regarder droit vers la caméra",
+		codeFaceTooSmall:           "Le visage est trop petit. Veuillez vous rapprocher de la caméra",
+		codeStaleEnrollment:        "L'inscription est trop ancienne, veuillez vous réinscrire",
+		codeNoEnrollmentImage:      "Aucune image d'inscription enregistrée, veuillez vous réinscrire",
+		codeNoFaceInDocument:       "Aucun visage exploitable sur la photo du document",
+		codeReplayDetected:         "Cette image a déjà été utilisée pour une vérification, veuillez en capturer une nouvelle",
+		codeEmailNotConfirmed:      "Veuillez d'abord confirmer votre adresse e-mail",
+		codeInvalidToken:           "Lien de confirmation invalide ou expiré",
+		codeEmailAlreadyConfirmed:  "Cette adresse e-mail est déjà confirmée",
+		codeUnexpectedUpstream:     "Réponse inattendue du service en amont",
+		codeUnauthorized:           "Identifiants invalides ou manquants",
+		codeForbidden:              "Accès refusé",
+
+		codeOrganizationExists:   "Cette organisation existe déjà",
+		codeOrganizationNotFound: "Cette organisation n'existe pas",
+
+		codeRegistrationNotFound: "Inscription échouée introuvable",
+	},
+	"es": {
+		codeMethodNotAllowed:       "Método no aceptado",
+		codeInvalidRequest:         "Solicitud no válida",
+		codeTrailingData:           "Datos sobrantes después del cuerpo JSON",
+		codeMissingFields:          "Todos los campos son obligatorios",
+		codeEmailExists:            "El correo electrónico ya existe",
+		codeEmailReserved:          "El correo electrónico ya está reservado por otro registro",
+		codeDuplicateName:          "Ya existe un usuario con este nombre",
+		codeUserNotFound:           "La cuenta de usuario no existe",
+		codeInternalError:          "Error interno del servidor",
+		codeRequestTimeout:         "La solicitud tardó demasiado, inténtelo de nuevo",
+		codeDatabaseError:          "Error de base de datos",
+		codeFaceServiceError:       "El servicio de reconocimiento facial devolvió un error",
+		codeReferenceUnavailable:   "No se pudo obtener la imagen de referencia",
+		codeInvalidImage:           "Imagen Base64 no válida",
+		codeImageRejected:          "No se pudo procesar la imagen",
+		codeImageTooDark:           "La imagen es demasiado oscura. Vuelva a tomar la foto con mejor iluminación",
+		codeLowContrast:            "El contraste de la imagen es demasiado bajo. Vuelva a tomar la foto con mejor iluminación",
+		codeGrayscaleImage:         "La imagen está en blanco y negro. Vuelva a tomar la foto en color",
+		codeImageTooLarge:          "La imagen es demasiado grande",
+		codeImageUploadFailed:      "Error al subir la imagen",
+		codeDailyLimitReached:      "Se alcanzó el límite diario de registros, inténtelo de nuevo mañana",
+		codeUserQuotaExceeded:      "Demasiadas verificaciones para este usuario, inténtelo más tarde",
+		codeTooManyConcurrent:      "Demasiadas solicitudes simultáneas, espere a que terminen las anteriores",
+		codeVerificationInProgress: "Ya hay una verificación en curso para este usuario",
+		codeMaintenance:            "Servicio en mantenimiento, inténtelo de nuevo más tarde",
+		codeSpoofDetected:          "Suplantación detectada. Utilice una captura de cámara en vivo",
+		codeAntiSpoofNotEvaluated:  "No se pudo evaluar la detección de suplantación",
+		codeFaceNotFrontal:         "El rostro no está de frente. Mire directamente a la cámara",
+		codeFaceTooSmall:           "El rostro es demasiado pequeño. Acérquese a la cámara",
+		codeStaleEnrollment:        "El registro es demasiado antiguo, vuelva a registrarse",
+		codeNoEnrollmentImage:      "No hay imagen de registro, vuelva a registrarse",
+		codeNoFaceInDocument:       "No se encontró un rostro utilizable en la foto del documento",
+		codeReplayDetected:         "Esta imagen ya se utilizó para una verificación, capture una nueva",
+		codeEmailNotConfirmed:      "Confirme primero su correo electrónico",
+		codeInvalidToken:           "Enlace de confirmación no válido o caducado",
+		codeEmailAlreadyConfirmed:  "El correo electrónico ya está confirmado",
+		codeUnexpectedUpstream:     "Respuesta inesperada del servicio externo",
+		codeUnauthorized:           "Credenciales no válidas o ausentes",
+		codeForbidden:              "Acceso denegado",
+
+		codeOrganizationExists:   "La organización ya existe",
+		codeOrganizationNotFound: "La organización no existe",
+
+		codeRegistrationNotFound: "Registro fallido no encontrado",
+	},
+}
+
+// requestLanguage picks the best supported language from the request's
+// Accept-Language header.
+func requestLanguage(r *http.Request) language.Tag {
+	tags, _, _ := language.ParseAcceptLanguage(r.Header.Get("Accept-Language"))
+	_, index, _ := languageMatcher.Match(tags...)
+	return supportedLanguages[index]
+}
+
+// localizedMessage returns the message for code in the request's language,
+// or fallback (the English message) when no translation exists.
+func localizedMessage(r *http.Request, code string, fallback string) (string, language.Tag) {
+	lang := requestLanguage(r)
+	base, _ := lang.Base()
+
+	if message, ok := messages[base.String()][code]; ok {
+		return message, lang
+	}
+	return fallback, language.English
+}
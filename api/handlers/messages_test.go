@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"testing"
+)
+
+// errorCodes returns every error code declared in errorCodes.go.
+func errorCodes(t *testing.T) []string {
+	t.Helper()
+	file, err := parser.ParseFile(token.NewFileSet(), "errorCodes.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var codes []string
+	ast.Inspect(file, func(node ast.Node) bool {
+		if lit, ok := node.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			code, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codes = append(codes, code)
+		}
+		return true
+	})
+	return codes
+}
+
+func TestMessagesCoverEveryCode(t *testing.T) {
+	codes := errorCodes(t)
+	if len(codes) == 0 {
+		t.Fatal("no error codes found in errorCodes.go")
+	}
+
+	for _, tag := range supportedLanguages[1:] {
+		lang := tag.String()
+		catalog, ok := messages[lang]
+		if !ok {
+			t.Errorf("no messages for %s", lang)
+			continue
+		}
+		for _, code := range codes {
+			if catalog[code] == "" {
+				t.Errorf("%s: no message for %s", lang, code)
+			}
+		}
+	}
+}
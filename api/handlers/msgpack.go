@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const msgpackContentType = "application/msgpack"
+
+// wantsMsgpack reports whether the client's Accept header asks for msgpack
+// and MSGPACK_RESPONSES allows it. JSON stays the default.
+func wantsMsgpack(r *http.Request) bool {
+	if !config.App.MsgpackResponses {
+		return false
+	}
+
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil || (mediaType != msgpackContentType && mediaType != "application/x-msgpack") {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// marshalMsgpack encodes payload as msgpack under the same field names as
+// its JSON, and adds the timestamp to maps like withTimestamp does to JSON
+// objects. Floats stay floats even when they hold whole numbers.
+func marshalMsgpack(payload interface{}, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.UseCompactInts(true)
+	if err := enc.Encode(payload); err != nil {
+		return nil, err
+	}
+	return withMsgpackTimestamp(buf.Bytes(), now)
+}
+
+// withMsgpackTimestamp adds a "timestamp" entry to an encoded msgpack map.
+// Other values are returned as they are.
+func withMsgpackTimestamp(encoded []byte, now time.Time) ([]byte, error) {
+	var size, header int
+	switch {
+	case len(encoded) >= 1 && encoded[0] >= 0x80 && encoded[0] <= 0x8f: // fixmap
+		size, header = int(encoded[0]&0x0f), 1
+	case len(encoded) >= 3 && encoded[0] == 0xde: // map 16
+		size, header = int(binary.BigEndian.Uint16(encoded[1:])), 3
+	case len(encoded) >= 5 && encoded[0] == 0xdf: // map 32
+		size, header = int(binary.BigEndian.Uint32(encoded[1:])), 5
+	default:
+		return encoded, nil
+	}
+
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	if err := enc.EncodeMapLen(size + 1); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString("timestamp"); err != nil {
+		return nil, err
+	}
+	if err := enc.EncodeString(now.UTC().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	buf.Write(encoded[header:])
+	return buf.Bytes(), nil
+}
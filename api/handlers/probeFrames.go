@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/models"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// probeFrames returns the probe images of a verify payload: the single
+// facial_image, or the burst of facial_images.
+func probeFrames(payload models.VerifyUserPayload) []string {
+	if payload.EncodedImage != "" {
+		return []string{payload.EncodedImage}
+	}
+	return payload.EncodedImages
+}
+
+// frameResult is the outcome of verifying one probe frame.
+type frameResult struct {
+	index    int
+	primary  *microservice.VerificationResponse
+	ensemble *microservice.VerificationResponse
+	err      error
+}
+
+// verifyFrames compares every frame with the reference, at most
+// PROBE_FRAME_CONCURRENCY at a time, and returns the closest match along
+// with how many frames could be compared. A single bad frame of a burst
+// (blurred, eyes closed) is then no reason to reject the user. Frames that
+// fail anti-spoofing by limits are only returned when no frame passes, so
+// a spoofed frame can't win on distance alone. When every frame fails, the
+// first frame's error is returned.
+func verifyFrames(ctx context.Context, request microservice.VerifyRequest, frames []string, limits thresholds.Thresholds) (frameResult, int) {
+	results := make([]frameResult, len(frames))
+	limit := make(chan struct{}, max(1, config.App.ProbeFrameConcurrency))
+
+	var wg sync.WaitGroup
+	for i, frame := range frames {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			frameRequest := request
+			frameRequest.VerImg = frame
+			primary, ensemble, err := verifyWithEnsemble(ctx, frameRequest)
+			results[i] = frameResult{index: i, primary: primary, ensemble: ensemble, err: err}
+		}()
+	}
+	wg.Wait()
+
+	best := results[0]
+	evaluated := 0
+	for _, result := range results {
+		if result.err != nil {
+			continue
+		}
+		evaluated++
+		if best.err != nil || isBetterFrame(result, best, limits) {
+			best = result
+		}
+	}
+	return best, evaluated
+}
+
+// isBetterFrame reports whether a compared frame should be kept over
+// another one: a live frame beats a spoofed one, then the closest wins.
+func isBetterFrame(frame, than frameResult, limits thresholds.Thresholds) bool {
+	frameSpoof, thanSpoof := isProbeSpoof(frame.primary, limits), isProbeSpoof(than.primary, limits)
+	if frameSpoof != thanSpoof {
+		return thanSpoof
+	}
+	return frame.primary.Distance < than.primary.Distance
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// RawVerify returns the distance between a user's reference image and a
+// probe, for calibrating thresholds against labeled data. It makes no match
+// decision and has no side effects: no quota, replay check, audit record or
+// anti-spoofing.
+func RawVerify(w http.ResponseWriter, r *http.Request) {
+	orgID, err := requestedOrgID(r)
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	var thisRequest models.RawVerifyPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.Email == "" || thisRequest.EncodedImage == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if isImageTooLarge(thisRequest.EncodedImage) {
+		respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+			map[string]interface{}{"max_chars": config.App.MaxImageChars})
+		return
+	}
+
+	user, err := fetchUser(orgID, thisRequest.Email)
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	var forwardedImage string
+	thisRequest.EncodedImage, forwardedImage, _, err = prepareForService(thisRequest.EncodedImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+
+	verificationResp, err := microservice.Service.Verify(r.Context(), microservice.VerifyRequest{
+		RegImg:       user.RegImageURL,
+		VerImg:       forwardedImage,
+		MinFaceRatio: config.App.MinFaceFraction,
+	})
+	if err != nil {
+		respondWithFaceServiceError(w, r, err)
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, map[string]float64{"distance": verificationResp.Distance})
+}
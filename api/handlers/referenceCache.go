@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"container/list"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// referenceFetchTimeout bounds the background download of a reference image.
+const referenceFetchTimeout = 30 * time.Second
+
+// referenceCache keeps recently used reference images in memory as data
+// URIs, up to REFERENCE_CACHE_BYTES of them, evicting the least recently
+// used. Verify
+// forwards cached images to the microservice directly, so its latency
+// doesn't depend on Cloudinary serving the reference in time.
+type referenceCache struct {
+	mu      sync.Mutex
+	entries map[int]*list.Element
+	order   *list.List // front is the most recently used
+	size    int
+}
+
+type referenceEntry struct {
+	userID       int
+	enrollmentID string
+	image        string
+}
+
+var references = &referenceCache{entries: make(map[int]*list.Element), order: list.New()}
+
+// referenceFetches lets concurrent misses on the same enrollment share one
+// download.
+var referenceFetches singleflight.Group
+
+// get returns the cached reference image of the user's current enrollment.
+// Entries from an earlier enrollment are ignored, which covers face updates
+// made through another instance.
+func (c *referenceCache) get(userID int, enrollmentID string) (string, bool) {
+	if config.App.ReferenceCacheBytes <= 0 {
+		return "", false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[userID]
+	if !ok || element.Value.(*referenceEntry).enrollmentID != enrollmentID {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*referenceEntry).image, true
+}
+
+func (c *referenceCache) put(userID int, enrollmentID string, image string) {
+	limit := config.App.ReferenceCacheBytes
+	if len(image) > limit {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.removeLocked(userID)
+	c.entries[userID] = c.order.PushFront(&referenceEntry{userID: userID, enrollmentID: enrollmentID, image: image})
+	c.size += len(image)
+
+	for c.size > limit {
+		c.removeLocked(c.order.Back().Value.(*referenceEntry).userID)
+	}
+}
+
+// invalidate drops the user's cached reference image.
+func (c *referenceCache) invalidate(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(userID)
+}
+
+func (c *referenceCache) removeLocked(userID int) {
+	element, ok := c.entries[userID]
+	if !ok {
+		return
+	}
+	c.size -= len(element.Value.(*referenceEntry).image)
+	c.order.Remove(element)
+	delete(c.entries, userID)
+}
+
+// warmReference downloads the user's reference image in the background and
+// caches it for the next verifications. Failures are only logged, the
+// microservice keeps fetching the URL itself meanwhile.
+func warmReference(requestID string, userID int, enrollmentID string, url string) {
+	if config.App.ReferenceCacheBytes <= 0 {
+		return
+	}
+
+	goBackground(func() {
+		bestEffort(requestID, "caching reference image", func() error {
+			_, err, _ := referenceFetches.Do(enrollmentID, func() (interface{}, error) {
+				image, err := fetchReference(url)
+				if err != nil {
+					return nil, err
+				}
+				references.put(userID, enrollmentID, image)
+				return nil, nil
+			})
+			return err
+		})
+	})
+}
+
+// fetchReference downloads an image and returns it as a data URI, unless
+// the data URI would be larger than the whole cache.
+func fetchReference(url string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), referenceFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("reference image returned status %d", resp.StatusCode)
+	}
+
+	// Anything whose Base64 alone exceeds the cache wouldn't be kept anyway
+	limit := config.App.ReferenceCacheBytes
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(base64.StdEncoding.DecodedLen(limit))+1))
+	if err != nil {
+		return "", err
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	image := "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	if len(image) > limit {
+		return "", fmt.Errorf("reference image larger than the cache")
+	}
+	log.Printf("Cached reference image from %s (%d bytes)", url, len(data))
+	return image, nil
+}
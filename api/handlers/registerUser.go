@@ -1,58 +1,76 @@
 package handlers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"time"
 
+	"github.com/kwagmire/facial-verification-api/config"
 	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/middleware"
 	"github.com/kwagmire/facial-verification-api/models"
 
-	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api"
 	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
 	"github.com/lib/pq"
 )
 
-// This struct matches the JSON payload for the microservice detect-face endpoint
-type detectFacePayload struct {
-	Img string `json:"img"`
-}
-
-// This struct matches the JSON response from our Python API
-type detectionResponse struct {
-	Status     string  `json:"status"`
-	IsReal     bool    `json:"is_real"`
-	AntiSScore float64 `json:"antispoof_score"`
-}
-
 func RegisterUser(w http.ResponseWriter, r *http.Request) {
+	summary, w := startSummary(w, r, "register")
+	defer summary.log()
+
 	if r.Method != http.MethodPost {
-		respondWithError(w, "Unaccepted method", http.StatusMethodNotAllowed)
+		respondWithError(w, r, codeMethodNotAllowed, "Unaccepted method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var thisRequest models.RegisterUserPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	summary.email = thisRequest.Email
+
+	if missing := missingRegistrationFields(thisRequest); len(missing) > 0 {
+		respondWithErrorFields(w, r, codeMissingFields, "Required fields are missing", http.StatusBadRequest,
+			map[string]interface{}{"missing_fields": missing})
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
+	if isImageTooLarge(thisRequest.EncodedImage) {
+		respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+			map[string]interface{}{"max_chars": config.App.MaxImageChars})
+		return
+	}
+
+	// Checked before anything is uploaded, the point of reserving the email
+	available, err := checkEmailReservation(r, thisRequest.Email, thisRequest.ReservationToken)
 	if err != nil {
-		respondWithError(w, "Error reading request body", http.StatusBadRequest)
+		respondWithInternalError(w, r, codeDatabaseError, "Error checking email reservation", err, http.StatusInternalServerError)
+		return
+	}
+	if !available {
+		respondWithError(w, r, codeEmailReserved, "Email is already reserved by another signup", http.StatusConflict)
 		return
 	}
 
-	var thisRequest models.RegisterUserPayload
-	err = json.Unmarshal(body, &thisRequest)
+	slot, allowed, err := reserveRegistrationSlot(r)
 	if err != nil {
-		respondWithError(w, "Invalid request payload", http.StatusBadRequest)
+		respondWithInternalError(w, r, codeDatabaseError, "Error checking registration quota", err, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		respondWithError(w, r, codeDailyLimitReached, "Daily registration limit reached, please try again tomorrow", http.StatusTooManyRequests)
 		return
 	}
 
-	if thisRequest.Email == "" ||
-		thisRequest.FirstName == "" ||
-		thisRequest.LastName == "" ||
-		thisRequest.EncodedImage == "" {
-		respondWithError(w, "All fields are required", http.StatusBadRequest)
+	var forwardedImage string
+	var scale float64
+	thisRequest.EncodedImage, forwardedImage, scale, err = prepareForService(thisRequest.EncodedImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
 		return
 	}
 
@@ -72,101 +90,165 @@ func RegisterUser(w http.ResponseWriter, r *http.Request) {
 	}
 	*/
 
-	const microserviceURL = "http://localhost:8001/detect-face"
-	// 2. Create the JSON payload
-	payload := detectFacePayload{
-		Img: thisRequest.EncodedImage,
-	}
-
-	// Marshal the payload struct into JSON bytes
-	jsonPayload, err := json.Marshal(payload)
-	if err != nil {
-		respondWithError(w, "error marshalling json: "+err.Error(), http.StatusInternalServerError)
+	// 2. Make sure the image contains exactly one real, frontal face
+	detection := checkEnrollmentFace(w, r, summary, callerOrgID(r), forwardedImage, scale)
+	if detection == nil {
 		return
 	}
 
-	// 3. Create and send the HTTP request
-	req, err := http.NewRequest("POST", microserviceURL, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		respondWithError(w, "error creating request: "+err.Error(), http.StatusInternalServerError)
-		return
+	user := newUser{
+		email:          storedEmail(thisRequest.Email),
+		firstName:      thisRequest.FirstName,
+		lastName:       thisRequest.LastName,
+		orgID:          callerOrgID(r),
+		antiSpoofScore: detection.AntiSScore,
+		quality:        enrollmentQuality(detection, thisRequest.EncodedImage),
 	}
 
-	// Set the Content-Type header to application/json
-	req.Header.Set("Content-Type", "application/json")
+	ctx := context.Background()
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	uploadResult, err := imagestore.Store.Upload(ctx, thisRequest.EncodedImage, uploader.UploadParams{Folder: referenceFolder(user.orgID)})
 	if err != nil {
-		respondWithError(w, "error sending request to python service: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// 4. Handle the response
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		respondWithError(w, "python service returned error (status "+strconv.Itoa(resp.StatusCode)+"): "+string(bodyBytes), http.StatusInternalServerError)
+		releaseRegistrationSlot(r, slot)
+		saveFailedRegistration(r, user, thisRequest.EncodedImage, err)
+		respondWithInternalError(w, r, codeImageUploadFailed, "Error uploading image to Cloudinary", err, http.StatusInternalServerError)
 		return
 	}
-
-	/* Decode the successful JSON response
-		var verificationResp detectionResponse
-		if err = json.NewDecoder(resp.Body).Decode(&verificationResp); err != nil {
-			respondWithError(w, "error decoding json response: "+err.Error(), http.StatusInternalServerError)
+	// The upload is deleted unless the user ends up registered
+	registered := false
+	defer func() {
+		if registered {
 			return
 		}
+		if _, err := imagestore.Store.Destroy(ctx, uploader.DestroyParams{PublicID: uploadResult.PublicID, Invalidate: api.Bool(true)}); err != nil {
+			log.Printf("request_id=%s: failed to delete orphaned upload %s: %v", middleware.GetRequestID(r.Context()), uploadResult.PublicID, err)
+		}
+	}()
 
-		return &verificationResp, nil
+	if err = imagestore.Store.CheckURL(uploadResult.SecureURL); err != nil {
+		releaseRegistrationSlot(r, slot)
+		saveFailedRegistration(r, user, thisRequest.EncodedImage, err)
+		respondWithInternalError(w, r, codeImageUploadFailed, "Cloudinary returned an invalid image URL", err, http.StatusInternalServerError)
+		return
 	}
-		_, err = core.CheckFace(baseFilepath)
-		if err != nil {
-			log.Printf("Failed to recognize file: %v", err)
-			respondWithError(w, "Failed to find a face", http.StatusUnprocessableEntity)
-			return
-		}*/
-
-	ctx := context.Background()
 
-	cld, err := cloudinary.New()
+	user.imageURL, user.publicID = uploadResult.SecureURL, uploadResult.PublicID
+	userID, enrollmentID, err := insertUser(user)
 	if err != nil {
-		log.Printf("Failed to create Cloudinary instance: %v", err)
-		respondWithError(w, "Error creating Cloudinary instance", http.StatusInternalServerError)
+		if !isUniqueViolation(err) {
+			releaseRegistrationSlot(r, slot)
+			saveFailedRegistration(r, user, thisRequest.EncodedImage, err)
+		}
+		respondWithUserInsertError(w, r, err)
 		return
 	}
 
-	uploadResult, err := cld.Upload.Upload(ctx, thisRequest.EncodedImage, uploader.UploadParams{})
-	if err != nil {
-		log.Printf("Failed to upload file: %v", err)
-		respondWithError(w, "Error uploading image to Cloudinary", http.StatusInternalServerError)
-		return
+	// The upload and the insert above must succeed. From here on the user is
+	// registered, and the remaining steps are best-effort: their failures are
+	// logged and roll nothing back.
+	registered = true
+	summary.result = resultRegistered
+	summary.enrollmentID = enrollmentID
+
+	releaseEmailReservation(r, thisRequest.Email)
+
+	if config.App.RequireEmailConfirmation {
+		sendConfirmationEmail(r, thisRequest.Email, confirmationToken(userID, time.Now()))
+	}
+	response := map[string]interface{}{
+		"message":       "Registration successful!",
+		"enrollment_id": enrollmentID,
+	}
+	if user.quality != nil {
+		response["enrollment_quality"] = *user.quality
+	}
+	if detection.AntiSScore == nil {
+		response["antispoof_not_evaluated"] = true
 	}
+	respondWithJSON(w, r, http.StatusCreated, response)
+}
+
+// newUser is a user about to be inserted, with email in its stored form.
+type newUser struct {
+	email     string
+	firstName string
+	lastName  string
+	orgID     *int
 
+	imageURL string
+	publicID string
+
+	antiSpoofScore *float64
+	quality        *int
+}
+
+// insertUser inserts u and returns its ID and enrollment ID.
+func insertUser(u newUser) (int, string, error) {
 	query := `
 		INSERT INTO users (
 			email,
 			first_name,
 			last_name,
-			regimage_url
-		) VALUES ($1, $2, $3, $4
-		) RETURNING id`
+			regimage_url,
+			regimage_public_id,
+			org_id,
+			enrollment_antispoof_score,
+			antispoof_evaluated,
+			enrollment_quality
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9
+		) RETURNING id, enrollment_id`
 	var userID int
-	err = db.DB.QueryRow(
+	var enrollmentID string
+	err := db.DB.QueryRow(
 		query,
-		thisRequest.Email,
-		thisRequest.FirstName,
-		thisRequest.LastName,
-		uploadResult.SecureURL,
-	).Scan(&userID)
-	if err != nil {
-		if dbError, ok := err.(*pq.Error); ok && dbError.Code.Name() == "unique_violation" {
-			respondWithError(w, "Email already exists", http.StatusConflict)
-			return
-		}
-		respondWithError(w, "Failed to register user: "+err.Error(), http.StatusInternalServerError)
+		u.email,
+		u.firstName,
+		u.lastName,
+		u.imageURL,
+		u.publicID,
+		u.orgID,
+		u.antiSpoofScore,
+		u.antiSpoofScore != nil,
+		u.quality,
+	).Scan(&userID, &enrollmentID)
+	return userID, enrollmentID, err
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation:
+// the email, or with REQUIRE_UNIQUE_NAME the name, is already taken.
+func isUniqueViolation(err error) bool {
+	dbError, ok := err.(*pq.Error)
+	return ok && dbError.Code.Name() == "unique_violation"
+}
+
+// respondWithUserInsertError reports a failed insertUser, with a 409 when
+// the email or name is already taken.
+func respondWithUserInsertError(w http.ResponseWriter, r *http.Request, err error) {
+	if isUniqueViolation(err) && err.(*pq.Error).Constraint == db.UniqueNameIndex {
+		respondWithError(w, r, codeDuplicateName, "A user with this name already exists", http.StatusConflict)
 		return
 	}
+	if isUniqueViolation(err) {
+		respondWithError(w, r, codeEmailExists, "Email already exists", http.StatusConflict)
+		return
+	}
+	respondWithInternalError(w, r, codeDatabaseError, "Failed to register user", err, http.StatusInternalServerError)
+}
 
-	respondWithJSON(w, http.StatusCreated, map[string]string{"message": "Registration successful!"})
+// missingRegistrationFields returns the REQUIRED_FIELDS left empty in payload.
+func missingRegistrationFields(payload models.RegisterUserPayload) []string {
+	values := map[string]string{
+		"email":        payload.Email,
+		"first_name":   payload.FirstName,
+		"last_name":    payload.LastName,
+		"facial_image": payload.EncodedImage,
+	}
+
+	var missing []string
+	for _, field := range config.App.RequiredFields {
+		if strings.TrimSpace(values[field]) == "" {
+			missing = append(missing, field)
+		}
+	}
+	return missing
 }
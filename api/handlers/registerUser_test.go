@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/microservice"
+)
+
+// mockDB replaces db.DB with a sqlmock connection for the test's duration.
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := db.DB
+	db.DB = conn
+	t.Cleanup(func() {
+		db.DB = previous
+		conn.Close()
+	})
+	return mock
+}
+
+// malformedURLStore is the fake store, except uploads come back with a
+// broken URL, like a Cloudinary response missing its secure_url.
+type malformedURLStore struct {
+	*imagestore.Fake
+}
+
+func (s malformedURLStore) Upload(ctx context.Context, file string, params uploader.UploadParams) (*uploader.UploadResult, error) {
+	result, err := s.Fake.Upload(ctx, file, params)
+	if err != nil {
+		return nil, err
+	}
+	result.SecureURL = "://"
+	return result, nil
+}
+
+func TestRegisterUserRejectsMalformedUploadURL(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectQuery(`FROM email_reservations`).WillReturnRows(sqlmock.NewRows([]string{"token_hash"}))
+
+	store := imagestore.NewFake()
+	previousStore, previousService := imagestore.Store, microservice.Service
+	imagestore.Store = malformedURLStore{store}
+	microservice.Service = microservice.Fake{}
+	defer func() { imagestore.Store, microservice.Service = previousStore, previousService }()
+
+	req := httptest.NewRequest(http.MethodPost, "/register",
+		strings.NewReader(`{"email": "jane@example.com", "first_name": "Jane", "last_name": "Doe", "facial_image": "aW1hZ2U="}`))
+	rec := httptest.NewRecorder()
+	RegisterUser(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusInternalServerError, rec.Code, rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != codeImageUploadFailed {
+		t.Errorf("expected code %s, got %v", codeImageUploadFailed, body["code"])
+	}
+	// No user may be inserted with the broken URL
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	// Nor may its upload be left behind
+	destroyed, err := store.Destroy(context.Background(), uploader.DestroyParams{PublicID: referenceFolder(nil) + "/fake_1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if destroyed.Result != "not found" {
+		t.Errorf("expected the upload to be deleted, it is still stored")
+	}
+}
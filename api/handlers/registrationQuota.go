@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// reserveRegistrationSlot counts a registration attempt against the caller's
+// daily quota and reports whether the attempt is allowed. The quota is kept
+// per integrator when the request carries an API key, per client IP
+// otherwise. Counts reset at midnight UTC. Trusted IPs, and every caller
+// when the quota is disabled, are always allowed. The slot taken is
+// returned, nil when the caller isn't counted.
+func reserveRegistrationSlot(r *http.Request) (*registrationSlot, bool, error) {
+	subject, counted := registrationSubject(r)
+	if !counted {
+		return nil, true, nil
+	}
+
+	// The conditional upsert increments and checks the counter atomically,
+	// so concurrent requests can't push an IP past its limit.
+	query := `
+		INSERT INTO registration_counts (subject, day, count)
+		VALUES ($1, (now() AT TIME ZONE 'UTC')::date, 1)
+		ON CONFLICT (subject, day) DO UPDATE
+			SET count = registration_counts.count + 1
+			WHERE registration_counts.count < $2
+		RETURNING day`
+	slot := registrationSlot{subject: subject}
+	err := db.DB.QueryRow(query, subject, config.App.MaxRegistrationsPerIPPerDay).Scan(&slot.day)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return &slot, true, nil
+}
+
+// registrationSlot is a registration counted by reserveRegistrationSlot.
+type registrationSlot struct {
+	subject string
+	day     time.Time
+}
+
+// releaseRegistrationSlot gives back a slot taken by reserveRegistrationSlot
+// when the registration fails on our side (upload, database), so our
+// failures don't eat into the caller's quota. Rejected images keep their
+// slot, or spoofs could be retried without limit. The slot is given back
+// to the day it was taken from, even past midnight.
+func releaseRegistrationSlot(r *http.Request, slot *registrationSlot) {
+	if slot == nil {
+		return
+	}
+
+	query := `
+		UPDATE registration_counts
+		SET count = count - 1
+		WHERE subject = $1 AND day = $2 AND count > 0`
+	if _, err := db.DB.Exec(query, slot.subject, slot.day); err != nil {
+		log.Printf("request_id=%s: failed to release registration slot: %v", middleware.GetRequestID(r.Context()), err)
+	}
+}
+
+// registrationSubject returns the key the caller's registrations are counted
+// under, and false when they aren't counted at all.
+func registrationSubject(r *http.Request) (string, bool) {
+	if config.App.MaxRegistrationsPerIPPerDay <= 0 {
+		return "", false
+	}
+
+	if caller := getIntegrator(r); caller != nil {
+		return "key:" + strconv.Itoa(caller.KeyID), true
+	}
+	ip := clientIP(r)
+	return ip, !config.App.IsTrustedIP(ip)
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// remapBatch is how many users RemapImageURLs rewrites per UPDATE, to keep
+// row locks short on large tables.
+const remapBatch = 500
+
+// RemapImageURLs replaces the "from" prefix of every stored reference image
+// URL with "to", after images were moved to another Cloudinary account or
+// domain. Without "confirm": true it only reports how many URLs would
+// change. Users are rewritten in batches of increasing ID, so it is safe to
+// run again after a failure.
+func RemapImageURLs(w http.ResponseWriter, r *http.Request) {
+	var thisRequest models.RemapImageURLsPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+
+	// A partial prefix like "https" would rewrite far more than intended
+	if !isAbsoluteURL(thisRequest.From) || !isAbsoluteURL(thisRequest.To) {
+		respondWithError(w, r, codeInvalidRequest, "from and to must be absolute URLs", http.StatusBadRequest)
+		return
+	}
+	if thisRequest.From == thisRequest.To {
+		respondWithError(w, r, codeInvalidRequest, "from and to must differ", http.StatusBadRequest)
+		return
+	}
+
+	if !thisRequest.Confirm {
+		var matched int
+		query := `SELECT count(*) FROM users WHERE left(regimage_url, length($1)) = $1`
+		if err := db.DB.QueryRow(query, thisRequest.From).Scan(&matched); err != nil {
+			respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+			return
+		}
+		respondWithJSON(w, r, http.StatusOK, map[string]interface{}{"dry_run": true, "matched": matched})
+		return
+	}
+
+	query := `
+		WITH batch AS (
+			SELECT id FROM users
+			WHERE id > $3 AND left(regimage_url, length($1)) = $1
+			ORDER BY id
+			LIMIT $4
+		)
+		UPDATE users
+		SET regimage_url = $2 || substr(regimage_url, length($1) + 1)
+		FROM batch
+		WHERE users.id = batch.id
+		RETURNING users.id`
+	remapped, lastID := 0, 0
+	for {
+		rows, err := db.DB.Query(query, thisRequest.From, thisRequest.To, lastID, remapBatch)
+		if err != nil {
+			log.Printf("request_id=%s: image URL remap stopped after %d users", middleware.GetRequestID(r.Context()), remapped)
+			respondWithInternalError(w, r, codeDatabaseError, "Failed to remap image URLs", err, http.StatusInternalServerError)
+			return
+		}
+
+		n := 0
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				break
+			}
+			lastID = max(lastID, id)
+			n++
+		}
+		if err == nil {
+			err = rows.Err()
+		}
+		rows.Close()
+		if err != nil {
+			log.Printf("request_id=%s: image URL remap stopped after %d users", middleware.GetRequestID(r.Context()), remapped)
+			respondWithInternalError(w, r, codeDatabaseError, "Failed to remap image URLs", err, http.StatusInternalServerError)
+			return
+		}
+
+		remapped += n
+		if n < remapBatch {
+			break
+		}
+	}
+
+	log.Printf("request_id=%s: remapped %d image URLs from %s to %s", middleware.GetRequestID(r.Context()), remapped, thisRequest.From, thisRequest.To)
+	respondWithJSON(w, r, http.StatusOK, map[string]interface{}{"dry_run": false, "remapped": remapped})
+}
+
+// isAbsoluteURL reports whether s is a URL with a scheme and a host.
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
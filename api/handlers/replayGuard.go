@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+type probeKey struct {
+	userID int
+	hash   [sha256.Size]byte
+}
+
+// replayGuard remembers the hashes of recently accepted probe images per
+// user. A live capture never produces the exact same bytes twice, so a
+// repeated hash means a captured payload is being replayed.
+type replayGuard struct {
+	mu        sync.Mutex
+	seen      map[probeKey]time.Time // expiry of each entry
+	lastSweep time.Time
+}
+
+var acceptedProbes = &replayGuard{seen: make(map[probeKey]time.Time)}
+
+// isReplay reports whether the probe with the given SHA-256 digest was
+// accepted for the user within the replay window.
+func (g *replayGuard) isReplay(userID int, digest [sha256.Size]byte) bool {
+	if config.App.ReplayWindow <= 0 {
+		return false
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	expiry, ok := g.seen[probeKey{userID, digest}]
+	return ok && time.Now().Before(expiry)
+}
+
+// remember records the probe with the given digest as accepted for the user.
+func (g *replayGuard) remember(userID int, digest [sha256.Size]byte) {
+	window := config.App.ReplayWindow
+	if window <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Now()
+	g.seen[probeKey{userID, digest}] = now.Add(window)
+
+	// Drop expired entries once per window to keep memory bounded
+	if now.Sub(g.lastSweep) > window {
+		for key, expiry := range g.seen {
+			if now.After(expiry) {
+				delete(g.seen, key)
+			}
+		}
+		g.lastSweep = now
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// Results reported in request summaries
+const (
+	resultRegistered    = "registered"
+	resultFaceUpdated   = "face_updated"
+	resultEmbedded      = "embedded"
+	resultMatched       = outcomeMatched
+	resultNoMatch       = outcomeNoMatch
+	resultSpoofRejected = outcomeSpoofRejected
+	resultError         = outcomeError
+	resultCancelled     = "cancelled"
+)
+
+// statusRecorder remembers the status code written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// requestSummary collects the outcome of a request for the single summary
+// line logged when it completes. It never holds image data.
+type requestSummary struct {
+	r        *http.Request
+	recorder *statusRecorder
+	start    time.Time
+	endpoint string
+
+	email        string
+	enrollmentID string
+	result       string
+	distance     *float64
+}
+
+// startSummary begins the summary of a request. The returned writer must be
+// used for the response so its status can be recorded, and log must be
+// deferred.
+func startSummary(w http.ResponseWriter, r *http.Request, endpoint string) (*requestSummary, http.ResponseWriter) {
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	return &requestSummary{r: r, recorder: recorder, start: time.Now(), endpoint: endpoint}, recorder
+}
+
+func (s *requestSummary) log() {
+	result := s.result
+	if result == "" {
+		result = resultError
+		if s.recorder.status == statusClientClosedRequest {
+			result = resultCancelled
+		}
+	}
+	// Non-matches stay unsampled: repeated ones against one account are how
+	// brute-force attempts show up in the logs
+	routine := s.recorder.status < http.StatusBadRequest &&
+		result != resultError && result != resultSpoofRejected && result != resultNoMatch
+	if routine && !sampleRoutineLog() {
+		return
+	}
+
+	attrs := []any{
+		slog.String("endpoint", s.endpoint),
+		slog.String("result", result),
+		slog.Int("status", s.recorder.status),
+		slog.Int64("duration_ms", time.Since(s.start).Milliseconds()),
+	}
+	if rate := config.App.LogSampleRate; routine && rate > 1 {
+		// Lets log analytics scale sampled counts back up
+		attrs = append(attrs, slog.Int("sample_rate", rate))
+	}
+	if s.email != "" {
+		attrs = append(attrs, slog.String("email", middleware.LoggedEmail(s.email)))
+	}
+	if s.enrollmentID != "" {
+		attrs = append(attrs, slog.String("enrollment_id", s.enrollmentID))
+	}
+	if s.distance != nil {
+		attrs = append(attrs, slog.Float64("distance", *s.distance))
+	}
+
+	middleware.Logger(s.r.Context()).Info("request completed", attrs...)
+}
+
+// routineLogs counts the routine (successful) requests, for sampling.
+var routineLogs atomic.Uint64
+
+// sampleRoutineLog reports whether a routine request should be logged: one
+// in LOG_SAMPLE_RATE is. Failures, non-matches and spoof rejections are
+// always logged.
+func sampleRoutineLog() bool {
+	rate := config.App.LogSampleRate
+	if rate <= 1 {
+		return true
+	}
+	return routineLogs.Add(1)%uint64(rate) == 0
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// WithTimeout puts a hard ceiling on the time h may take. Past it the client
+// gets a 503 with a JSON error body and h's context is cancelled, whichever
+// downstream call it is stuck on. A zero timeout leaves h unbounded.
+// h keeps running after the 503 until it notices, so it is tracked to let
+// shutdown wait for it.
+func WithTimeout(h http.HandlerFunc, timeout time.Duration) http.Handler {
+	h = track(h)
+	if timeout <= 0 {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The deadline is set here rather than by http.TimeoutHandler so the
+		// body can carry it: the 503 is written as soon as it passes, so it
+		// is the time the body is served, as respondWithJSON timestamps
+		// every other body.
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+
+		// http.TimeoutHandler's body is fixed once it is made, so it is made
+		// per request, in the client's language
+		message, lang := localizedMessage(r, codeRequestTimeout, "Request timed out, please try again")
+		body, _ := json.Marshal(map[string]string{
+			"error": message,
+			"code":  codeRequestTimeout,
+		})
+		body = withTimestamp(body, deadline)
+
+		// Set up front as http.TimeoutHandler writes its body without headers
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Language", lang.String())
+		http.TimeoutHandler(h, timeout, string(body)).ServeHTTP(w, r.WithContext(ctx))
+	})
+}
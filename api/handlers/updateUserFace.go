@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+
+	"github.com/cloudinary/cloudinary-go/v2/api"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// UpdateUserFace replaces a user's reference image. The image is uploaded
+// in place under a public ID derived from the user ID, so regimage_url stays
+// the same across re-enrollments and no asset is left orphaned.
+func UpdateUserFace(w http.ResponseWriter, r *http.Request) {
+	summary, w := startSummary(w, r, "update_face")
+	defer summary.log()
+
+	orgID, err := requestedOrgID(r)
+	if err != nil {
+		respondWithError(w, r, codeInvalidRequest, "Invalid organization ID", http.StatusBadRequest)
+		return
+	}
+
+	var thisRequest models.UpdateFacePayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	summary.email = r.PathValue("email")
+
+	if thisRequest.EncodedImage == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if isImageTooLarge(thisRequest.EncodedImage) {
+		respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+			map[string]interface{}{"max_chars": config.App.MaxImageChars})
+		return
+	}
+
+	user, err := fetchUser(orgID, r.PathValue("email"))
+	if err == sql.ErrNoRows {
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	var forwardedImage string
+	var scale float64
+	thisRequest.EncodedImage, forwardedImage, scale, err = prepareForService(thisRequest.EncodedImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+
+	detection := checkEnrollmentFace(w, r, summary, orgID, forwardedImage, scale)
+	if detection == nil {
+		return
+	}
+
+	quality := enrollmentQuality(detection, thisRequest.EncodedImage)
+
+	ctx := context.Background()
+
+	publicID := referencePublicID(orgID, user.ID)
+	uploadResult, err := imagestore.Store.Upload(ctx, thisRequest.EncodedImage, uploader.UploadParams{
+		PublicID:   publicID,
+		Overwrite:  api.Bool(true),
+		Invalidate: api.Bool(true),
+	})
+	if err != nil {
+		respondWithInternalError(w, r, codeImageUploadFailed, "Error uploading image to Cloudinary", err, http.StatusInternalServerError)
+		return
+	}
+	if err = imagestore.Store.CheckURL(uploadResult.SecureURL); err != nil {
+		respondWithInternalError(w, r, codeImageUploadFailed, "Cloudinary returned an invalid image URL", err, http.StatusInternalServerError)
+		return
+	}
+
+	query := `
+		UPDATE users
+		SET regimage_url = $1, regimage_public_id = $2, enrollment_antispoof_score = $3, antispoof_evaluated = $4, enrollment_quality = $5, enrollment_id = gen_random_uuid()
+		WHERE id = $6
+		RETURNING enrollment_id`
+	var enrollmentID string
+	err = db.DB.QueryRow(query, stableImageURL(uploadResult.SecureURL), publicID, detection.AntiSScore, detection.AntiSScore != nil, quality, user.ID).Scan(&enrollmentID)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Failed to update user", err, http.StatusInternalServerError)
+		return
+	}
+
+	// Users enrolled before in-place uploads have their image under a random
+	// public ID, which is now orphaned.
+	if old := user.RegImagePublicID; old.Valid && old.String != publicID {
+		if _, err = imagestore.Store.Destroy(ctx, uploader.DestroyParams{PublicID: old.String, Invalidate: api.Bool(true)}); err != nil {
+			log.Printf("request_id=%s: failed to delete previous reference image %s: %v", middleware.GetRequestID(r.Context()), old.String, err)
+		}
+	}
+
+	references.invalidate(user.ID)
+
+	summary.result = resultFaceUpdated
+	summary.enrollmentID = enrollmentID
+	response := map[string]interface{}{
+		"message":       "Face updated successfully!",
+		"enrollment_id": enrollmentID,
+	}
+	if quality != nil {
+		response["enrollment_quality"] = *quality
+	}
+	respondWithJSON(w, r, http.StatusOK, response)
+}
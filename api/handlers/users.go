@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/kwagmire/facial-verification-api/buildinfo"
+	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/models"
+)
+
+// Outcomes recorded for each verification attempt
+const (
+	outcomeMatched       = "matched"
+	outcomeNoMatch       = "no_match"
+	outcomeSpoofRejected = "spoof_rejected"
+	outcomeError         = "error"
+)
+
+// userRecord is a users row as needed by the admin endpoints.
+type userRecord struct {
+	models.UserProfile
+	RegImageURL      string
+	RegImagePublicID sql.NullString
+}
+
+// fetchUser loads the user registered with email in the organization orgID
+// (nil for users registered without an API key). It returns sql.ErrNoRows
+// when there is none.
+func fetchUser(orgID *int, email string) (*userRecord, error) {
+	query := `
+		SELECT
+			id,
+			email,
+			first_name,
+			last_name,
+			created_at,
+			regimage_url,
+			regimage_public_id,
+			enrollment_quality
+		FROM users
+		WHERE email = $1 AND org_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL`
+	var user userRecord
+	err := db.DB.QueryRow(query, storedEmail(email), orgID).Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.CreatedAt,
+		&user.RegImageURL,
+		&user.RegImagePublicID,
+		&user.EnrollmentQuality,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only a hash is stored when EMAIL_HASHING is on
+	user.Email = email
+	return &user, nil
+}
+
+// requestedOrgID parses the optional org_id query parameter admin endpoints
+// use to pick the organization of the user they act on.
+func requestedOrgID(r *http.Request) (*int, error) {
+	value := r.URL.Query().Get("org_id")
+	if value == "" {
+		return nil, nil
+	}
+
+	orgID, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &orgID, nil
+}
+
+// fetchVerificationAttempts returns the user's verification attempts, newest first.
+func fetchVerificationAttempts(userID int) ([]models.VerificationAttempt, error) {
+	query := `
+		SELECT
+			outcome,
+			is_match,
+			distance,
+			threshold,
+			COALESCE(client_ip, ''),
+			COALESCE(model_version, ''),
+			COALESCE(api_version, ''),
+			COALESCE(enrollment_id::text, ''),
+			created_at
+		FROM verification_attempts
+		WHERE user_id = $1
+		ORDER BY created_at DESC`
+	rows, err := db.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []models.VerificationAttempt{}
+	for rows.Next() {
+		var attempt models.VerificationAttempt
+		err = rows.Scan(
+			&attempt.Outcome,
+			&attempt.IsMatch,
+			&attempt.Distance,
+			&attempt.Threshold,
+			&attempt.ClientIP,
+			&attempt.ModelVersion,
+			&attempt.APIVersion,
+			&attempt.EnrollmentID,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// recordVerificationAttempt stores the outcome of a verification in the
+// audit table, along with the enrollment it was compared against. result
+// may be nil when the face service call failed. Failures are logged only:
+// the audit trail must not break verification itself. probe is kept for
+// failed outcomes when STORE_FAILED_PROBES is on.
+func recordVerificationAttempt(r *http.Request, userID int, enrollmentID, outcome string, result *microservice.VerificationResponse, probe string) {
+	var isMatch *bool
+	var distance, threshold *float64
+	var modelVersion *string
+	if result != nil {
+		isMatch = &result.IsMatch
+		distance = &result.Distance
+		threshold = &result.Threshold
+		if result.ModelVersion != "" {
+			modelVersion = &result.ModelVersion
+		}
+	}
+
+	var probePublicID *string
+	if outcome == outcomeNoMatch || outcome == outcomeSpoofRejected {
+		probePublicID = storeFailedProbe(r, probe)
+	}
+
+	query := `
+		INSERT INTO verification_attempts (
+			user_id,
+			outcome,
+			is_match,
+			distance,
+			threshold,
+			client_ip,
+			probe_public_id,
+			model_version,
+			api_version,
+			enrollment_id
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := db.DB.Exec(query, userID, outcome, isMatch, distance, threshold, clientIP(r), probePublicID, modelVersion, buildinfo.Version, enrollmentID)
+	if err != nil {
+		log.Printf("request_id=%s: failed to record verification attempt: %v", middleware.GetRequestID(r.Context()), err)
+	}
+}
@@ -0,0 +1,28 @@
+package handlers
+
+import (
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+)
+
+// withinVerificationQuota reports whether the user may attempt another
+// verification. Every recorded attempt in the rolling window counts,
+// whatever its outcome.
+func withinVerificationQuota(userID int) (bool, error) {
+	limit := config.App.UserVerifyQuota
+	if limit <= 0 {
+		return true, nil
+	}
+
+	query := `
+		SELECT COUNT(*)
+		FROM verification_attempts
+		WHERE user_id = $1
+			AND created_at > now() - make_interval(secs => $2)`
+	var count int
+	err := db.DB.QueryRow(query, userID, config.App.UserVerifyQuotaWindow.Seconds()).Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count < limit, nil
+}
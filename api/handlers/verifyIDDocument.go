@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/models"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+)
+
+// VerifyIDDocument matches a live selfie against the photo on an identity
+// document, for onboarding checks. It is stateless: nobody needs to be
+// enrolled and nothing is stored. The document photo is the reference, so
+// anti-spoofing and the minimum face size only apply to the selfie.
+func VerifyIDDocument(w http.ResponseWriter, r *http.Request) {
+	summary, w := startSummary(w, r, "verify_id_document")
+	defer summary.log()
+
+	var thisRequest models.VerifyIDDocumentPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
+		return
+	}
+	if thisRequest.DocumentImage == "" || thisRequest.EncodedImage == "" {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if isImageTooLarge(thisRequest.DocumentImage) || isImageTooLarge(thisRequest.EncodedImage) {
+		respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+			map[string]interface{}{"max_chars": config.App.MaxImageChars})
+		return
+	}
+
+	_, document, _, err := prepareForService(thisRequest.DocumentImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+	_, selfie, scale, err := prepareForService(thisRequest.EncodedImage)
+	if err != nil {
+		respondWithImageError(w, r, err)
+		return
+	}
+
+	verifyAgainstReference(w, r, summary, document, selfie, scale, true)
+}
+
+// verifyAgainstReference matches probe, downscaled by scale, against
+// reference without involving any enrolled user, and responds. document is
+// set when reference is the photo of an ID document.
+func verifyAgainstReference(w http.ResponseWriter, r *http.Request, summary *requestSummary, reference, probe string, scale float64, document bool) {
+	verificationResp, err := microservice.Service.Verify(r.Context(), microservice.VerifyRequest{
+		RegImg:       reference,
+		VerImg:       probe,
+		AntiSpoofing: config.App.VerifyAntiSpoof,
+		MinFaceRatio: config.App.MinFaceFraction,
+		// A document photo is a photo of a photo, which the comparison's
+		// anti-spoofing would take for a spoof
+		CompareAntiSpoofing: !document,
+	})
+	var statusErr *microservice.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest {
+		// Not the selfie's fault, the client has to retake the document
+		if document && statusErr.Reason() == microservice.ReasonNoFaceInReference {
+			respondWithError(w, r, codeNoFaceInDocument, "No usable face found in the document photo", http.StatusUnprocessableEntity)
+			return
+		}
+		if statusErr.Reason() == microservice.ReasonNoFace {
+			summary.result = resultNoMatch
+			respondWithJSON(w, r, config.App.NonMatchStatus, noFaceResponse())
+			return
+		}
+		// Too small faces get the same answer as at enrollment
+		if detail := statusErr.Detail(); detail.Reason == microservice.ReasonFaceTooSmall || detail.Reason == microservice.ReasonLowQuality {
+			respondWithFaceTooSmall(w, r, originalSize(detail.FaceSize, scale), originalSize(detail.ImageSize, scale))
+			return
+		}
+		if statusErr.Reason() == microservice.ReasonSpoofDetected {
+			summary.result = resultSpoofRejected
+			respondWithSpoofDetected(w, r, reasonSpoofDetected)
+			return
+		}
+	}
+	if err != nil {
+		respondWithFaceServiceError(w, r, err)
+		return
+	}
+
+	verificationResp.ProbeFaceSize = originalSize(verificationResp.ProbeFaceSize, scale)
+	if isFaceTooSmall(verificationResp.ProbeFaceSize) {
+		respondWithFaceTooSmall(w, r, verificationResp.ProbeFaceSize, nil)
+		return
+	}
+
+	antiSpoofNotEvaluated := isAntiSpoofMissing(verificationResp)
+	if antiSpoofNotEvaluated && !allowMissingAntiSpoof(r, "verification") {
+		respondWithErrorFields(w, r, codeAntiSpoofNotEvaluated, "Anti-spoofing could not be evaluated", http.StatusBadGateway,
+			map[string]interface{}{"liveness_status": livenessUnavailable})
+		return
+	}
+
+	limits := thresholds.ForOrg(callerOrgID(r))
+	if isProbeSpoof(verificationResp, limits) {
+		summary.result = resultSpoofRejected
+		respondWithSpoofDetected(w, r, reasonSpoofDetected)
+		return
+	}
+
+	uncertain := applyThresholds(verificationResp, limits)
+
+	reason, status := "", http.StatusOK
+	summary.result = resultMatched
+	if !verificationResp.IsMatch {
+		reason, status = reasonDistanceAboveThreshold, config.App.NonMatchStatus
+		summary.result = resultNoMatch
+	}
+	summary.distance = &verificationResp.Distance
+
+	if r.URL.Query().Get("raw") != "true" {
+		roundScores(verificationResp, config.App.RoundDecimals)
+	}
+
+	respondWithJSON(w, r, status, verifyUserResponse{
+		VerificationResponse: verificationResp,
+		Uncertain:            uncertain,
+		Reason:               reason,
+
+		AntiSpoofNotEvaluated: antiSpoofNotEvaluated,
+		LivenessStatus:        livenessStatus(antiSpoofNotEvaluated),
+	})
+}
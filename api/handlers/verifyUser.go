@@ -1,74 +1,245 @@
 package handlers
 
 import (
-	"bytes"
+	"crypto/sha256"
 	"database/sql"
-	"encoding/json"
-	"io"
+	"errors"
+	"log"
+	"math"
 	"net/http"
-	"strconv"
+	"time"
 
+	"github.com/kwagmire/facial-verification-api/buildinfo"
+	"github.com/kwagmire/facial-verification-api/config"
 	"github.com/kwagmire/facial-verification-api/db"
+	"github.com/kwagmire/facial-verification-api/imageproc"
+	"github.com/kwagmire/facial-verification-api/metrics"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
 	"github.com/kwagmire/facial-verification-api/models"
+	"github.com/kwagmire/facial-verification-api/resulttoken"
+	"github.com/kwagmire/facial-verification-api/thresholds"
 )
 
-type verifyFacePayload struct {
-	RegImg string `json:"regimg"`
-	VerImg string `json:"verimg"`
-}
+type verifyUserResponse struct {
+	*microservice.VerificationResponse
+	StaleEnrollment bool   `json:"stale_enrollment,omitempty"`
+	Uncertain       bool   `json:"uncertain,omitempty"`
+	ResultToken     string `json:"result_token,omitempty"`
+	Reason          string `json:"reason,omitempty"`
+
+	// The enrollment the probe was compared against
+	EnrollmentID string `json:"enrollment_id,omitempty"`
+
+	// Set when the face service gave no anti-spoofing verdict for the probe
+	// and ANTISPOOF_MISSING_POLICY is "allow"
+	AntiSpoofNotEvaluated bool `json:"antispoof_not_evaluated,omitempty"`
+
+	// Tells "not live" apart from "couldn't check", for clients that must
+	// fail secure
+	LivenessStatus string `json:"liveness_status"`
+
+	// The probe is closer to the enrollment than a live capture can be
+	SuspiciousExactMatch bool `json:"suspicious_exact_match,omitempty"`
+
+	// How many frames of a burst could be compared, the best one is returned
+	FramesEvaluated int `json:"frames_evaluated,omitempty"`
+
+	// Set along with model_version, unless INCLUDE_VERSIONS is off
+	APIVersion string `json:"api_version,omitempty"`
 
-type verificationResponse struct {
-	IsMatch   bool    `json:"is_match"`
-	Distance  float64 `json:"distance"`
-	Threshold float64 `json:"threshold"`
-	Time      float64 `json:"time"`
+	// Only set along with probe_antispoof_score, for comparison
+	EnrollmentAntiSpoofScore *float64 `json:"enrollment_antispoof_score,omitempty"`
+
+	// Raw results of each model, when running as an ensemble
+	Models []microservice.VerificationResponse `json:"models,omitempty"`
 }
 
+// Reasons given alongside a failed verification, so clients can tell users
+// whether to retry. The probe related ones come from the microservice.
+const (
+	reasonDistanceAboveThreshold = "distance_above_threshold"
+	reasonSpoofDetected          = "spoof_detected"
+	reasonExactMatch             = "suspicious_exact_match"
+
+	// Given for images the microservice rejected without a known reason
+	reasonUnprocessableImage = "unprocessable_image"
+)
+
 func VerifyUser(w http.ResponseWriter, r *http.Request) {
+	summary, w := startSummary(w, r, "verify")
+	defer summary.log()
+
+	activeVerifications.Add(1)
+	metrics.ActiveVerifications.Inc()
+	defer func() {
+		activeVerifications.Add(-1)
+		metrics.ActiveVerifications.Dec()
+	}()
+
 	if r.Method != http.MethodPost {
-		respondWithError(w, "Unaccepted method", http.StatusMethodNotAllowed)
+		respondWithError(w, r, codeMethodNotAllowed, "Unaccepted method", http.StatusMethodNotAllowed)
 		return
 	}
 
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		respondWithError(w, "Error reading request body", http.StatusBadRequest)
+	var thisRequest models.VerifyUserPayload
+	if !decodeJSONBody(w, r, &thisRequest) {
 		return
 	}
+	summary.email = thisRequest.Email
 
-	var thisRequest models.VerifyUserPayload
-	err = json.Unmarshal(body, &thisRequest)
-	if err != nil {
-		respondWithError(w, "Invalid request payload", http.StatusBadRequest)
+	frames := probeFrames(thisRequest)
+	if len(frames) == 0 || (thisRequest.Email == "" && thisRequest.UserID == nil && thisRequest.RegImageURL == "") {
+		respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+		return
+	}
+	if thisRequest.Email != "" && thisRequest.UserID != nil {
+		respondWithError(w, r, codeInvalidRequest, "Provide either email or user_id, not both", http.StatusBadRequest)
+		return
+	}
+	// Otherwise the caller might believe the named user was checked
+	if thisRequest.RegImageURL != "" && (thisRequest.Email != "" || thisRequest.UserID != nil) {
+		respondWithError(w, r, codeInvalidRequest, "reg_image_url can't be combined with email or user_id", http.StatusBadRequest)
+		return
+	}
+	if thisRequest.EncodedImage != "" && len(thisRequest.EncodedImages) > 0 {
+		respondWithError(w, r, codeInvalidRequest, "Provide either facial_image or facial_images, not both", http.StatusBadRequest)
+		return
+	}
+	if len(frames) > config.App.MaxProbeFrames {
+		respondWithErrorFields(w, r, codeInvalidRequest, "Too many frames", http.StatusBadRequest,
+			map[string]interface{}{"max_frames": config.App.MaxProbeFrames})
 		return
 	}
 
-	if thisRequest.Email == "" || thisRequest.EncodedImage == "" {
-		respondWithError(w, "All fields are required", http.StatusBadRequest)
+	for _, frame := range frames {
+		if frame == "" {
+			respondWithError(w, r, codeMissingFields, "All fields are required", http.StatusBadRequest)
+			return
+		}
+		if isImageTooLarge(frame) {
+			respondWithErrorFields(w, r, codeImageTooLarge, "Image is too large", http.StatusRequestEntityTooLarge,
+				map[string]interface{}{"max_chars": config.App.MaxImageChars})
+			return
+		}
+	}
+
+	if thisRequest.RegImageURL != "" {
+		verifyInlineReference(w, r, summary, thisRequest.RegImageURL, frames)
 		return
 	}
 
+	// Server-to-server callers identify users by ID rather than email
+	lookupColumn, lookupKey := "email", interface{}(storedEmail(thisRequest.Email))
+	if thisRequest.UserID != nil {
+		lookupColumn, lookupKey = "id", *thisRequest.UserID
+	}
+
 	query := `
 		SELECT
 			id,
-			regimage_url
+			COALESCE(regimage_url, ''),
+			created_at,
+			email_confirmed,
+			enrollment_antispoof_score,
+			enrollment_id
 		FROM users
-		WHERE email = $1`
+		WHERE ` + lookupColumn + ` = $1 AND org_id IS NOT DISTINCT FROM $2 AND deleted_at IS NULL`
 	var userID int
 	var baseImageURL string
-	err = db.DB.QueryRow(query, thisRequest.Email).Scan(
+	var enrolledAt time.Time
+	var emailConfirmed bool
+	var enrollmentAntiSpoofScore *float64
+	var enrollmentID string
+	err := db.DB.QueryRow(query, lookupKey, callerOrgID(r)).Scan(
 		&userID,
 		&baseImageURL,
+		&enrolledAt,
+		&emailConfirmed,
+		&enrollmentAntiSpoofScore,
+		&enrollmentID,
 	)
 	if err == sql.ErrNoRows {
-		respondWithError(w, "User account doesn't exist", http.StatusUnauthorized)
+		respondWithError(w, r, codeUserNotFound, "User account doesn't exist", http.StatusUnauthorized)
 		return
 	}
 	if err != nil {
-		respondWithError(w, "Database error: "+err.Error(), http.StatusInternalServerError)
+		respondWithInternalError(w, r, codeDatabaseError, "Database error", err, http.StatusInternalServerError)
+		return
+	}
+
+	summary.enrollmentID = enrollmentID
+
+	// Rows left without a reference by a bad migration or bug would
+	// otherwise get a confusing error from the microservice
+	if baseImageURL == "" {
+		log.Printf("request_id=%s: user %d has no reference image", middleware.GetRequestID(r.Context()), userID)
+		respondWithError(w, r, codeNoEnrollmentImage, "No enrollment image on file, please re-enroll", http.StatusConflict)
+		return
+	}
+
+	if config.App.RequireEmailConfirmation && !emailConfirmed {
+		respondWithError(w, r, codeEmailNotConfirmed, "Please confirm your email first", http.StatusForbidden)
 		return
 	}
 
+	// Held until the handler returns, which is soon after a timeout as
+	// the downstream calls are cancelled with the request
+	if config.App.OneVerificationPerUser {
+		if !lockUserVerification(userID) {
+			respondWithError(w, r, codeVerificationInProgress, "A verification is already in progress for this user", http.StatusConflict)
+			return
+		}
+		defer unlockUserVerification(userID)
+	}
+
+	allowed, err := withinVerificationQuota(userID)
+	if err != nil {
+		respondWithInternalError(w, r, codeDatabaseError, "Error checking verification quota", err, http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		respondWithError(w, r, codeUserQuotaExceeded, "Too many verifications for this user, please try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	// Old enrollment photos may no longer represent the user
+	staleEnrollment := isStaleEnrollment(enrolledAt)
+	if staleEnrollment && config.App.RejectStaleEnrollment {
+		respondWithError(w, r, codeStaleEnrollment, "Enrollment is too old, please re-enroll", http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Each frame is decoded once, and only its digest is kept past this loop
+	digests := make([][sha256.Size]byte, len(frames))
+	processed := make([]string, len(frames))
+	forwarded := make([]string, len(frames))
+	scales := make([]float64, len(frames))
+	for i, frame := range frames {
+		data, err := imageproc.Decode(frame)
+		if err != nil {
+			respondWithImageError(w, r, err)
+			return
+		}
+		digests[i] = sha256.Sum256(data)
+		if acceptedProbes.isReplay(userID, digests[i]) {
+			respondWithError(w, r, codeReplayDetected, "This image was already used for a verification, please capture a new one", http.StatusConflict)
+			return
+		}
+
+		processed[i], forwarded[i], scales[i], err = imageproc.Prepare(frame, data, config.App.ForwardMaxDimension)
+		if err != nil {
+			respondWithImageError(w, r, err)
+			return
+		}
+	}
+
+	reference, cached := references.get(userID, enrollmentID)
+	if !cached {
+		reference = baseImageURL
+		warmReference(middleware.GetRequestID(r.Context()), userID, enrollmentID, baseImageURL)
+	}
+
 	/*1. Decode the Base64 string into bytes.
 	decodedData, err := base64.StdEncoding.DecodeString(thisRequest.EncodedImage)
 	if err != nil {
@@ -83,51 +254,283 @@ func VerifyUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}*/
 
-	const microserviceURL = "http://localhost:8001/verify"
-	// 2. Create the JSON payload
-	payload := verifyFacePayload{
-		RegImg: baseImageURL,
-		VerImg: thisRequest.EncodedImage,
-	}
+	// 2. Compare the probe with the registered image
+	limits := thresholds.ForOrg(callerOrgID(r))
+	best, framesEvaluated := verifyFrames(r.Context(), microservice.VerifyRequest{
+		RegImg:              reference,
+		AntiSpoofing:        config.App.VerifyAntiSpoof,
+		MinFaceRatio:        config.App.MinFaceFraction,
+		CompareAntiSpoofing: true,
+	}, forwarded, limits)
+	verificationResp, ensembleResp, err := best.primary, best.ensemble, best.err
 
-	// Marshal the payload struct into JSON bytes
-	jsonPayload, err := json.Marshal(payload)
+	// The rest only concerns the frame that was kept
+	thisRequest.EncodedImage = processed[best.index]
+	digest, forwardedImage, scale := digests[best.index], forwarded[best.index], scales[best.index]
+	if len(frames) == 1 {
+		framesEvaluated = 0
+	}
+	var statusErr *microservice.StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusBadRequest {
+		// A probe without a usable face is a failed verification, not an error
+		if statusErr.Reason() == microservice.ReasonNoFace {
+			summary.result = resultNoMatch
+			recordVerificationAttempt(r, userID, enrollmentID, outcomeNoMatch, nil, thisRequest.EncodedImage)
+			response := noFaceResponse()
+			response.StaleEnrollment = staleEnrollment
+			response.EnrollmentID = enrollmentID
+			response.FramesEvaluated = framesEvaluated
+			if config.App.IncludeVersions {
+				response.APIVersion = buildinfo.Version
+			} else {
+				response.ModelVersion = ""
+			}
+			respondWithJSON(w, r, config.App.NonMatchStatus, response)
+			return
+		}
+		// Too small faces get the same answer as at enrollment
+		if detail := statusErr.Detail(); detail.Reason == microservice.ReasonFaceTooSmall || detail.Reason == microservice.ReasonLowQuality {
+			recordVerificationAttempt(r, userID, enrollmentID, outcomeError, nil, "")
+			respondWithFaceTooSmall(w, r, originalSize(detail.FaceSize, scale), originalSize(detail.ImageSize, scale))
+			return
+		}
+		if statusErr.Reason() == microservice.ReasonSpoofDetected {
+			summary.result = resultSpoofRejected
+			recordVerificationAttempt(r, userID, enrollmentID, outcomeSpoofRejected, nil, thisRequest.EncodedImage)
+			respondWithSpoofDetected(w, r, reasonSpoofDetected)
+			return
+		}
+	}
 	if err != nil {
-		respondWithError(w, "error marshalling json: "+err.Error(), http.StatusInternalServerError)
+		recordVerificationAttempt(r, userID, enrollmentID, outcomeError, nil, "")
+		respondWithFaceServiceError(w, r, err)
 		return
 	}
 
-	// 3. Create and send the HTTP request
-	req, err := http.NewRequest("POST", microserviceURL, bytes.NewBuffer(jsonPayload))
-	if err != nil {
-		respondWithError(w, "error creating request: "+err.Error(), http.StatusInternalServerError)
+	// Face sizes are reported in the client's pixels, not the downscaled ones
+	verificationResp.ProbeFaceSize = originalSize(verificationResp.ProbeFaceSize, scale)
+	if ensembleResp != nil {
+		ensembleResp.ProbeFaceSize = originalSize(ensembleResp.ProbeFaceSize, scale)
+	}
+
+	if isFaceTooSmall(verificationResp.ProbeFaceSize) {
+		recordVerificationAttempt(r, userID, enrollmentID, outcomeError, verificationResp, "")
+		respondWithFaceTooSmall(w, r, verificationResp.ProbeFaceSize, nil)
 		return
 	}
 
-	// Set the Content-Type header to application/json
-	req.Header.Set("Content-Type", "application/json")
+	antiSpoofNotEvaluated := isAntiSpoofMissing(verificationResp)
+	if antiSpoofNotEvaluated && !allowMissingAntiSpoof(r, "verification") {
+		recordVerificationAttempt(r, userID, enrollmentID, outcomeError, verificationResp, "")
+		respondWithErrorFields(w, r, codeAntiSpoofNotEvaluated, "Anti-spoofing could not be evaluated", http.StatusBadGateway,
+			map[string]interface{}{"liveness_status": livenessUnavailable})
+		return
+	}
 
-	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		respondWithError(w, "error sending request to python service: "+err.Error(), http.StatusInternalServerError)
+	if isProbeSpoof(verificationResp, limits) {
+		summary.result = resultSpoofRejected
+		recordVerificationAttempt(r, userID, enrollmentID, outcomeSpoofRejected, verificationResp, thisRequest.EncodedImage)
+		respondWithSpoofDetected(w, r, reasonSpoofDetected)
 		return
 	}
-	defer resp.Body.Close()
 
-	// 4. Handle the response
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		respondWithError(w, "python service returned error (status "+strconv.Itoa(resp.StatusCode)+"): "+string(bodyBytes), http.StatusInternalServerError)
+	// Checked on the primary model, whose distance the floor is meant for
+	suspiciousExactMatch := verificationResp.Distance < config.App.ExactMatchFloor
+	if suspiciousExactMatch {
+		log.Printf("request_id=%s: probe for user %d is a suspiciously exact match (distance %v)",
+			middleware.GetRequestID(r.Context()), userID, verificationResp.Distance)
+		if config.App.RejectExactMatch {
+			summary.result = resultSpoofRejected
+			recordVerificationAttempt(r, userID, enrollmentID, outcomeSpoofRejected, verificationResp, thisRequest.EncodedImage)
+			respondWithSpoofDetected(w, r, reasonExactMatch)
+			return
+		}
+	}
+
+	// Kept before thresholds and the ensemble decision change the primary
+	var models []microservice.VerificationResponse
+	if ensembleResp != nil {
+		models = []microservice.VerificationResponse{*verificationResp, *ensembleResp}
+	}
+
+	// The ensemble's go first, they are derived from the primary's model
+	// threshold that applyThresholds replaces
+	if ensembleResp != nil {
+		applyThresholds(ensembleResp, ensembleThresholds(limits, verificationResp, ensembleResp))
+	}
+	uncertain := applyThresholds(verificationResp, limits)
+	if ensembleResp != nil {
+		verificationResp.IsMatch = combineVerdicts(verificationResp, ensembleResp, config.App.EnsembleMode)
+	}
+
+	outcome, reason := outcomeNoMatch, reasonDistanceAboveThreshold
+	if verificationResp.IsMatch {
+		outcome, reason = outcomeMatched, ""
+	} else if isSuspiciousNonMatch(verificationResp) && !probeHasSingleFace(r, forwardedImage) {
+		reason = microservice.ReasonNoFace
+	}
+	recordVerificationAttempt(r, userID, enrollmentID, outcome, verificationResp, thisRequest.EncodedImage)
+	summary.result = outcome
+	summary.distance = &verificationResp.Distance
+	if verificationResp.IsMatch {
+		acceptedProbes.remember(userID, digest)
+	}
+
+	// Lets a downstream party (e.g. a lock controller) trust the result offline
+	var resultToken string
+	if config.App.ResultTokenSecret != "" {
+		resultToken, err = resulttoken.Issue([]byte(config.App.ResultTokenSecret), userID, verificationResp.IsMatch, time.Now(), config.App.ResultTokenTTL)
+		if err != nil {
+			respondWithInternalError(w, r, codeInternalError, "Error signing result", err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Decisions above use raw values, rounding is for presentation only
+	if r.URL.Query().Get("raw") != "true" {
+		roundScores(verificationResp, config.App.RoundDecimals)
+	}
+
+	// A probe scoring far below its enrollment may be a spoofing attempt
+	if verificationResp.ProbeAntiSpoofScore == nil {
+		enrollmentAntiSpoofScore = nil
+	}
+
+	status := http.StatusOK
+	if !verificationResp.IsMatch {
+		status = config.App.NonMatchStatus
+	}
+
+	var apiVersion string
+	if config.App.IncludeVersions {
+		apiVersion = buildinfo.Version
+	} else {
+		verificationResp.ModelVersion = ""
+		for i := range models {
+			models[i].ModelVersion = ""
+		}
+	}
+	respondWithJSON(w, r, status, verifyUserResponse{
+		VerificationResponse: verificationResp,
+		StaleEnrollment:      staleEnrollment,
+		Uncertain:            uncertain,
+		ResultToken:          resultToken,
+		Reason:               reason,
+		Models:               models,
+		EnrollmentID:         enrollmentID,
+		APIVersion:           apiVersion,
+
+		AntiSpoofNotEvaluated: antiSpoofNotEvaluated,
+		LivenessStatus:        livenessStatus(antiSpoofNotEvaluated),
+		SuspiciousExactMatch:  suspiciousExactMatch,
+		FramesEvaluated:       framesEvaluated,
+
+		EnrollmentAntiSpoofScore: enrollmentAntiSpoofScore,
+	})
+}
+
+// noFaceResponse is the non-match answered when the probe has no usable
+// face. Nothing was compared, so the comparison fields are zero and liveness
+// is unavailable.
+func noFaceResponse() verifyUserResponse {
+	return verifyUserResponse{
+		VerificationResponse: &microservice.VerificationResponse{ModelVersion: config.App.ModelVersion},
+		Reason:               microservice.ReasonNoFace,
+		LivenessStatus:       livenessUnavailable,
+	}
+}
+
+// roundScores rounds the floating point fields of resp to the given number of
+// decimals, so clients get stable values. Non-positive decimals do nothing.
+func roundScores(resp *microservice.VerificationResponse, decimals int) {
+	if decimals <= 0 {
 		return
 	}
 
-	// Decode the successful JSON response
-	var verificationResp verificationResponse
-	if err = json.NewDecoder(resp.Body).Decode(&verificationResp); err != nil {
-		respondWithError(w, "error decoding json response: "+err.Error(), http.StatusInternalServerError)
+	scale := math.Pow10(decimals)
+	round := func(value float64) float64 {
+		return math.Round(value*scale) / scale
+	}
+	resp.Distance = round(resp.Distance)
+	resp.Threshold = round(resp.Threshold)
+	resp.Time = round(resp.Time)
+}
+
+// isProbeSpoof reports whether the probe failed anti-spoofing, either by the
+// microservice's verdict or by scoring below the configured minimum.
+func isProbeSpoof(resp *microservice.VerificationResponse, limits thresholds.Thresholds) bool {
+	if resp.ProbeIsReal != nil && !*resp.ProbeIsReal {
+		return true
 	}
+	return resp.ProbeAntiSpoofScore != nil && *resp.ProbeAntiSpoofScore < limits.AntiSpoofMin
+}
+
+// respondWithSpoofDetected rejects a probe that failed a spoof check.
+func respondWithSpoofDetected(w http.ResponseWriter, r *http.Request, reason string) {
+	respondWithErrorFields(w, r, codeSpoofDetected, "Spoof detected. Please use a live camera capture", http.StatusUnprocessableEntity,
+		map[string]interface{}{"reason": reason, "liveness_status": livenessNotLive})
+}
 
-	respondWithJSON(w, http.StatusOK, verificationResp)
+// Values of liveness_status. Liveness is unavailable when anti-spoofing is
+// turned off or the face service gave no verdict.
+const (
+	livenessLive        = "live"
+	livenessNotLive     = "not_live"
+	livenessUnavailable = "unavailable"
+)
+
+// livenessStatus returns the liveness_status of a probe that passed the
+// spoof checks.
+func livenessStatus(antiSpoofNotEvaluated bool) string {
+	if !config.App.VerifyAntiSpoof || antiSpoofNotEvaluated {
+		return livenessUnavailable
+	}
+	return livenessLive
+}
+
+// isAntiSpoofMissing reports whether anti-spoofing was asked for the probe
+// but the face service gave no verdict.
+func isAntiSpoofMissing(resp *microservice.VerificationResponse) bool {
+	return config.App.VerifyAntiSpoof && (resp.ProbeIsReal == nil || resp.ProbeAntiSpoofScore == nil)
+}
+
+// applyThresholds re-evaluates the match against the runtime match threshold
+// when one is set, and reports whether the distance is close enough to the
+// threshold to be considered uncertain.
+func applyThresholds(resp *microservice.VerificationResponse, limits thresholds.Thresholds) bool {
+	if limits.MatchThreshold > 0 {
+		resp.Threshold = limits.MatchThreshold
+		resp.IsMatch = resp.Distance <= limits.MatchThreshold
+	}
+	return limits.UncertaintyBand > 0 && math.Abs(resp.Distance-resp.Threshold) <= limits.UncertaintyBand
+}
+
+// isSuspiciousNonMatch reports whether a non-match is far enough from the
+// threshold that the probe may not contain a usable face at all.
+func isSuspiciousNonMatch(resp *microservice.VerificationResponse) bool {
+	return config.App.ProbeRecheck && resp.Distance > resp.Threshold*config.App.ProbeRecheckRatio
+}
+
+// probeHasSingleFace runs face detection on the probe. It only reports false
+// when detection found no face or several; when it can't tell, the
+// non-match is left as it is.
+func probeHasSingleFace(r *http.Request, probe string) bool {
+	_, err := microservice.Service.DetectFace(r.Context(), probe, 0)
+
+	var statusErr *microservice.StatusError
+	if errors.As(err, &statusErr) {
+		reason := statusErr.Reason()
+		return reason != microservice.ReasonNoFaceDetected && reason != microservice.ReasonMultipleFaces
+	}
+	return true
+}
+
+// isStaleEnrollment reports whether an enrollment made at enrolledAt is
+// older than MAX_ENROLLMENT_AGE_DAYS.
+func isStaleEnrollment(enrolledAt time.Time) bool {
+	maxAge := config.App.MaxEnrollmentAgeDays
+	if maxAge <= 0 {
+		return false
+	}
+	return time.Since(enrolledAt) > time.Duration(maxAge)*24*time.Hour
 }
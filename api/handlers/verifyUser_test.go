@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// A user row without a reference image, e.g. left behind by a bad migration,
+// is reported as such before anything is sent to the microservice.
+func TestVerifyUserNoEnrollmentImage(t *testing.T) {
+	mock := mockDB(t)
+
+	previousFrames := config.App.MaxProbeFrames
+	config.App.MaxProbeFrames = 1
+	defer func() { config.App.MaxProbeFrames = previousFrames }()
+
+	mock.ExpectQuery(`COALESCE\(regimage_url, ''\)`).
+		WithArgs("jane@example.com", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "regimage_url", "created_at", "email_confirmed", "enrollment_antispoof_score", "enrollment_id"}).
+			AddRow(7, "", time.Now(), true, nil, "5b0f7c1e-3d52-4a8e-9b1f-2c7d6e4a9f10"))
+
+	req := httptest.NewRequest(http.MethodPost, "/verify",
+		strings.NewReader(`{"email": "jane@example.com", "facial_image": "aW1hZ2U="}`))
+	rec := httptest.NewRecorder()
+	VerifyUser(rec, req)
+
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusConflict, rec.Code, rec.Body)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["code"] != codeNoEnrollmentImage {
+		t.Errorf("expected code %s, got %v", codeNoEnrollmentImage, body["code"])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/kwagmire/facial-verification-api/buildinfo"
+)
+
+// Version reports which build is running.
+func Version(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, buildinfo.Get())
+}
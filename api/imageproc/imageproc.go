@@ -0,0 +1,206 @@
+// Package imageproc normalizes the Base64 images sent by clients before they
+// are forwarded to the face microservice or uploaded to Cloudinary.
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"github.com/kwagmire/facial-verification-api/config"
+
+	// Registers the "RIFF????WEBPVP8" magic bytes with the image package
+	_ "golang.org/x/image/webp"
+)
+
+// jpegQuality is used when transcoding images the pipeline can't take as-is.
+const jpegQuality = 90
+
+// ErrInvalidImage is returned when the image isn't valid Base64.
+var ErrInvalidImage = errors.New("invalid Base64 image")
+
+// Process prepares a Base64 image, optionally wrapped in a data URI, for the
+// face microservice and storage. Images it has to transcode, rotate, flatten
+// or strip of metadata come back as a data URI; any other image is returned
+// unchanged, in whichever form it was sent.
+func Process(encoded string) (string, error) {
+	data, err := Decode(encoded)
+	if err != nil {
+		return "", err
+	}
+	result, err := process(encoded, data)
+	return result.encoded, err
+}
+
+// Prepare processes an image like Process, then downscales the result like
+// Downscale, for callers that already decoded data from encoded. The Base64
+// is decoded once, and so are the pixels when processing needed them. It
+// returns the processed image, for storage, and the one to forward to the
+// face microservice along with the factor it was scaled by. An image that
+// can't be downscaled is forwarded as processed, for the microservice to
+// reject.
+func Prepare(encoded string, data []byte, maxDimension int) (processed, forwarded string, scale float64, err error) {
+	result, err := process(encoded, data)
+	if err != nil {
+		return "", "", 1, err
+	}
+	forwarded, scale, err = downscale(result, maxDimension)
+	if err != nil {
+		return result.encoded, result.encoded, 1, nil
+	}
+	return result.encoded, forwarded, scale, nil
+}
+
+// processedImage is an image as process returns it.
+type processedImage struct {
+	encoded string // Base64 or data URI, as Process returns it
+	data    []byte
+	img     image.Image // nil unless processing needed the pixels
+}
+
+// process is Process on data, decoded from encoded.
+func process(encoded string, data []byte) (processedImage, error) {
+	unchanged := processedImage{encoded: encoded, data: data}
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		// Unknown formats are left for the microservice to reject
+		return unchanged, nil
+	}
+
+	// Decoded once, by the first step needing the pixels
+	var img image.Image
+	decode := func() (image.Image, error) {
+		if img == nil {
+			decoded, _, err := image.Decode(bytes.NewReader(data))
+			if err != nil {
+				return nil, ErrInvalidImage
+			}
+			img = decoded
+		}
+		return img, nil
+	}
+
+	if qualityChecked() {
+		// Undecodable images are left for the microservice to reject
+		if pixels, err := decode(); err == nil {
+			if err := checkQuality(pixels); err != nil {
+				return processedImage{}, err
+			}
+		}
+	}
+
+	// WebP isn't reliably supported downstream, so it is sent on as JPEG.
+	// JPEG has no alpha, so transparent areas get the flatten background.
+	if format == "webp" {
+		img, err := decode()
+		if err != nil {
+			return processedImage{}, err
+		}
+		if hasAlpha(img) {
+			img = flatten(img, config.App.FlattenBackground)
+		}
+		return encodeJPEG(img)
+	}
+
+	// Phone cameras store pixels as shot and flag the rotation in EXIF,
+	// which downstream decoders ignore. Re-encoding also drops the EXIF;
+	// upright photos have it cut out instead, so GPS coordinates and camera
+	// details never leave this service either way.
+	if format == "jpeg" {
+		if orientation := exifOrientation(data); orientation != 1 {
+			img, err := decode()
+			if err != nil {
+				return processedImage{}, err
+			}
+			return encodeJPEG(orient(img, orientation))
+		}
+		if stripped, ok := stripMetadata(data); ok {
+			return processedImage{encoded: dataURI("image/jpeg", stripped), data: stripped, img: img}, nil
+		}
+	}
+
+	if format == "png" && config.App.FlattenPNGAlpha {
+		img, err := decode()
+		if err != nil {
+			return processedImage{}, err
+		}
+		if hasAlpha(img) {
+			return encodePNG(flatten(img, config.App.FlattenBackground))
+		}
+	}
+
+	unchanged.img = img
+	return unchanged, nil
+}
+
+// Decode strips an optional data URI prefix and decodes the Base64 payload.
+func Decode(encoded string) ([]byte, error) {
+	if strings.HasPrefix(encoded, "data:") {
+		_, payload, found := strings.Cut(encoded, ",")
+		if !found {
+			return nil, ErrInvalidImage
+		}
+		encoded = payload
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+	return data, nil
+}
+
+// hasAlpha reports whether img has an alpha channel with any non-opaque pixel.
+func hasAlpha(img image.Image) bool {
+	switch img.ColorModel() {
+	case color.RGBAModel, color.RGBA64Model, color.NRGBAModel, color.NRGBA64Model,
+		color.AlphaModel, color.Alpha16Model:
+	default:
+		// Paletted images may still carry transparent entries
+		if _, ok := img.(*image.Paletted); !ok {
+			return false
+		}
+	}
+
+	if opaque, ok := img.(interface{ Opaque() bool }); ok {
+		return !opaque.Opaque()
+	}
+	return true
+}
+
+// flatten draws img over a solid background, removing transparency.
+func flatten(img image.Image, background color.Color) image.Image {
+	bounds := img.Bounds()
+	flat := image.NewRGBA(bounds)
+	draw.Draw(flat, bounds, image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, bounds, img, bounds.Min, draw.Over)
+	return flat
+}
+
+// encodePNG returns img encoded as a PNG data URI.
+func encodePNG(img image.Image) (processedImage, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return processedImage{}, err
+	}
+	return processedImage{encoded: dataURI("image/png", buf.Bytes()), data: buf.Bytes(), img: img}, nil
+}
+
+// encodeJPEG returns img encoded as a JPEG data URI.
+func encodeJPEG(img image.Image) (processedImage, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		return processedImage{}, err
+	}
+	return processedImage{encoded: dataURI("image/jpeg", buf.Bytes()), data: buf.Bytes(), img: img}, nil
+}
+
+func dataURI(mediaType string, data []byte) string {
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,190 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// readFixture returns a file from testdata as Base64.
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+// decodeDataURI decodes the image in a data URI returned by Process, which
+// must be of the given media type.
+func decodeDataURI(t *testing.T, uri, mediaType string) image.Image {
+	t.Helper()
+	payload, ok := strings.CutPrefix(uri, "data:"+mediaType+";base64,")
+	if !ok {
+		t.Fatalf("expected a %s data URI, got %.40q", mediaType, uri)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestProcessWebP(t *testing.T) {
+	config.App.FlattenBackground = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+
+	// 8x6 lossless WebP, with a transparent top-left pixel
+	processed, err := Process(readFixture(t, "small.webp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := decodeDataURI(t, processed, "image/jpeg")
+	if size := img.Bounds().Size(); size != image.Pt(8, 6) {
+		t.Errorf("size = %v, want 8x6", size)
+	}
+	// JPEG has no alpha, the transparent pixel must have been flattened
+	// onto the white background rather than turned black
+	if luma := color.GrayModel.Convert(img.At(0, 0)).(color.Gray).Y; luma < 200 {
+		t.Errorf("transparent pixel has luma %d, want it flattened to white", luma)
+	}
+}
+
+func TestProcessOrientation(t *testing.T) {
+	// Each fixture is stored so that, once its EXIF orientation is applied,
+	// it shows a 64x32 image with red, green, blue and white quadrants
+	// starting top-left and going left to right.
+	quadrants := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{16, 8, color.RGBA{R: 0xff, A: 0xff}},
+		{48, 8, color.RGBA{G: 0xff, A: 0xff}},
+		{16, 24, color.RGBA{B: 0xff, A: 0xff}},
+		{48, 24, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}},
+	}
+
+	tests := []struct {
+		orientation int
+		name        string
+	}{
+		{1, "upright"},
+		{2, "mirrored"},
+		{3, "rotated 180°"},
+		{4, "flipped"},
+		{5, "transposed"},
+		{6, "rotated 90° clockwise"},
+		{7, "transversed"},
+		{8, "rotated 90° counter-clockwise"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processed, err := Process(readFixture(t, fmt.Sprintf("orientation_%d.jpg", tt.orientation)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := Decode(processed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte("Exif\x00\x00")) {
+				t.Error("EXIF was forwarded")
+			}
+
+			img := decodeDataURI(t, processed, "image/jpeg")
+			if size := img.Bounds().Size(); size != image.Pt(64, 32) {
+				t.Fatalf("size = %v, want 64x32", size)
+			}
+			for _, q := range quadrants {
+				r, g, b, _ := img.At(q.x, q.y).RGBA()
+				got := color.RGBA{R: uint8(r >> 8), G: uint8(g >> 8), B: uint8(b >> 8), A: 0xff}
+				if !closeTo(got, q.want) {
+					t.Errorf("pixel (%d,%d) = %v, want %v", q.x, q.y, got, q.want)
+				}
+			}
+		})
+	}
+}
+
+// closeTo reports whether two colors match within JPEG compression noise.
+func closeTo(a, b color.RGBA) bool {
+	// Compared as ints, uint8 differences would wrap around
+	near := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return d > -48 && d < 48
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
+
+// benchmarkPhoto returns a 3024x4032 JPEG, the size of a 12MP phone photo,
+// as Base64. Its noise keeps it from compressing to an unrealistic size.
+func benchmarkPhoto(b *testing.B) string {
+	b.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 3024, 4032))
+	for y := 0; y < 4032; y++ {
+		for x := 0; x < 3024; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x ^ y), G: uint8(x * 3), B: uint8(y * 5), A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+		b.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// BenchmarkDownscale measures shrinking a phone photo on its own, as done
+// when the pixels weren't already decoded by processing.
+func BenchmarkDownscale(b *testing.B) {
+	photo := benchmarkPhoto(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := Downscale(photo, 1024); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkPrepare measures the per-frame work of a verification, from the
+// Base64 field of the request to the image forwarded to the microservice.
+// With quality checks on, the pixels decoded for them are reused to
+// downscale.
+func BenchmarkPrepare(b *testing.B) {
+	photo := benchmarkPhoto(b)
+	for _, quality := range []bool{false, true} {
+		name := "plain"
+		if quality {
+			name = "quality_checked"
+		}
+		b.Run(name, func(b *testing.B) {
+			config.App.RejectGrayscale = quality
+			defer func() { config.App.RejectGrayscale = false }()
+
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				data, err := Decode(photo)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, _, _, err := Prepare(photo, data, 1024); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package imageproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// exifOrientationTag is the TIFF tag holding the EXIF orientation.
+const exifOrientationTag = 0x0112
+
+// exifOrientation returns the EXIF orientation (1 to 8) of a JPEG, or 1 when
+// it has none or the metadata can't be read.
+func exifOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Image data starts, there is no metadata past this point
+			return 1
+		}
+
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			return 1
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return tiffOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+// stripMetadata removes the EXIF, XMP (both APP1) and IPTC (APP13) segments
+// of a JPEG, leaving the image data untouched, and reports whether it had
+// any. Malformed files are returned as they are.
+func stripMetadata(data []byte) ([]byte, bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return data, false
+	}
+
+	// Only allocated once there is something to strip
+	var stripped []byte
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return data, false
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if size < 2 || pos+2+size > len(data) {
+			return data, false
+		}
+		switch {
+		case marker == 0xE1 || marker == 0xED:
+			if stripped == nil {
+				stripped = append(make([]byte, 0, len(data)), data[:pos]...)
+			}
+		case stripped != nil:
+			stripped = append(stripped, data[pos:pos+2+size]...)
+		}
+		pos += 2 + size
+	}
+	if stripped == nil {
+		return data, false
+	}
+	return append(stripped, data[pos:]...), true
+}
+
+// tiffOrientation reads the orientation tag from the first IFD of the TIFF
+// structure embedded in an EXIF segment.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) != exifOrientationTag {
+			continue
+		}
+
+		orientation := int(order.Uint16(tiff[entry+8:]))
+		if orientation < 1 || orientation > 8 {
+			return 1
+		}
+		return orientation
+	}
+	return 1
+}
+
+// orient returns img transformed so it displays upright without relying on
+// its EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	bounds := img.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+
+	// source maps a pixel of the upright image to the stored one
+	var source func(x, y int) (int, int)
+	switch orientation {
+	case 2: // mirrored
+		source = func(x, y int) (int, int) { return sw - 1 - x, y }
+	case 3: // rotated 180°
+		source = func(x, y int) (int, int) { return sw - 1 - x, sh - 1 - y }
+	case 4: // flipped
+		source = func(x, y int) (int, int) { return x, sh - 1 - y }
+	case 5: // transposed
+		source = func(x, y int) (int, int) { return y, x }
+	case 6: // needs a 90° clockwise turn
+		source = func(x, y int) (int, int) { return y, sh - 1 - x }
+	case 7: // transversed
+		source = func(x, y int) (int, int) { return sw - 1 - y, sh - 1 - x }
+	case 8: // needs a 90° counter-clockwise turn
+		source = func(x, y int) (int, int) { return sw - 1 - y, x }
+	default:
+		return img
+	}
+
+	// Orientations 5 to 8 swap width and height
+	w, h := sw, sh
+	if orientation >= 5 {
+		w, h = sh, sw
+	}
+	upright := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := source(x, y)
+			upright.Set(x, y, img.At(bounds.Min.X+sx, bounds.Min.Y+sy))
+		}
+	}
+	return upright
+}
@@ -0,0 +1,108 @@
+package imageproc
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// Problems a QualityError can report.
+const (
+	ProblemTooDark     = "too_dark"
+	ProblemLowContrast = "low_contrast"
+	ProblemGrayscale   = "grayscale"
+)
+
+// QualityError is returned for captures too poor to give a reliable match.
+// Brightness is the mean luma (0-255), Contrast its standard deviation.
+type QualityError struct {
+	Problem    string
+	Brightness float64
+	Contrast   float64
+}
+
+func (e *QualityError) Error() string {
+	return fmt.Sprintf("image rejected as %s (brightness %.1f, contrast %.1f)", e.Problem, e.Brightness, e.Contrast)
+}
+
+// qualitySamples is roughly how many pixels are sampled per side; measuring
+// every pixel of a large photo isn't worth the time.
+const qualitySamples = 256
+
+// grayscaleTolerance is how far apart a pixel's channels may be for it to
+// still count as gray, to allow for JPEG noise.
+const grayscaleTolerance = 8
+
+// qualityChecked reports whether any of the checks of checkQuality is on.
+func qualityChecked() bool {
+	return config.App.MinImageBrightness > 0 || config.App.MinImageContrast > 0 || config.App.RejectGrayscale
+}
+
+// checkQuality rejects images below the configured brightness and contrast
+// floors, and grayscale ones when REJECT_GRAYSCALE is set.
+func checkQuality(img image.Image) error {
+	brightness, contrast, gray := measure(img)
+	switch {
+	case brightness < config.App.MinImageBrightness:
+		return &QualityError{Problem: ProblemTooDark, Brightness: brightness, Contrast: contrast}
+	case contrast < config.App.MinImageContrast:
+		return &QualityError{Problem: ProblemLowContrast, Brightness: brightness, Contrast: contrast}
+	case gray && config.App.RejectGrayscale:
+		return &QualityError{Problem: ProblemGrayscale, Brightness: brightness, Contrast: contrast}
+	}
+	return nil
+}
+
+// Contrast returns the standard deviation of the luma of a Base64 image,
+// on the same 0-255 scale as MIN_IMAGE_CONTRAST.
+func Contrast(encoded string) (float64, error) {
+	data, err := Decode(encoded)
+	if err != nil {
+		return 0, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 0, ErrInvalidImage
+	}
+
+	_, contrast, _ := measure(img)
+	return contrast, nil
+}
+
+// measure samples img on a grid and returns its mean luma, the standard
+// deviation of the luma, and whether every sampled pixel is gray.
+func measure(img image.Image) (float64, float64, bool) {
+	bounds := img.Bounds()
+	stepX := max(1, bounds.Dx()/qualitySamples)
+	stepY := max(1, bounds.Dy()/qualitySamples)
+
+	var sum, sumSquares float64
+	var n int
+	gray := true
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += stepY {
+		for x := bounds.Min.X; x < bounds.Max.X; x += stepX {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+
+			// Rec. 601 luma, as used by JPEG
+			luma := 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+			sum += luma
+			sumSquares += luma * luma
+			n++
+
+			if gray && max(r, g, b)-min(r, g, b) > grayscaleTolerance {
+				gray = false
+			}
+		}
+	}
+	if n == 0 {
+		return 0, 0, false
+	}
+
+	mean := sum / float64(n)
+	variance := math.Max(0, sumSquares/float64(n)-mean*mean)
+	return mean, math.Sqrt(variance), gray
+}
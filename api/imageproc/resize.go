@@ -0,0 +1,90 @@
+package imageproc
+
+import (
+	"bytes"
+	"image"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"golang.org/x/image/draw"
+)
+
+// Downscale shrinks a Base64 image so neither side exceeds maxDimension,
+// keeping its aspect ratio, and returns it along with the factor it was
+// scaled by. Images already small enough, in an unknown format, or with
+// maxDimension zero are returned as-is with a factor of 1.
+func Downscale(encoded string, maxDimension int) (string, float64, error) {
+	if maxDimension <= 0 {
+		return encoded, 1, nil
+	}
+
+	data, err := Decode(encoded)
+	if err != nil {
+		return "", 1, err
+	}
+	return downscale(processedImage{encoded: encoded, data: data}, maxDimension)
+}
+
+// downscale is Downscale on an image process returned, whose pixels are
+// only decoded if process didn't already.
+func downscale(source processedImage, maxDimension int) (string, float64, error) {
+	if maxDimension <= 0 {
+		return source.encoded, 1, nil
+	}
+
+	img := source.img
+	var width, height int
+	if img != nil {
+		width, height = img.Bounds().Dx(), img.Bounds().Dy()
+	} else {
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(source.data))
+		if err != nil {
+			return source.encoded, 1, nil
+		}
+		width, height = cfg.Width, cfg.Height
+	}
+	if max(width, height) <= maxDimension {
+		return source.encoded, 1, nil
+	}
+
+	if img == nil {
+		decoded, _, err := image.Decode(bytes.NewReader(source.data))
+		if err != nil {
+			return "", 1, ErrInvalidImage
+		}
+		img = decoded
+	}
+	if hasAlpha(img) {
+		img = flatten(img, config.App.FlattenBackground)
+	}
+
+	scale := float64(maxDimension) / float64(max(width, height))
+	width = max(1, int(float64(width)*scale+0.5))
+	height = max(1, int(float64(height)*scale+0.5))
+
+	downscaled, err := encodeJPEG(resize(img, width, height))
+	if err != nil {
+		return "", 1, err
+	}
+	return downscaled.encoded, scale, nil
+}
+
+// resize scales img down to width x height. It halves img while it is at
+// least twice the target size, each halving averaging blocks of 2x2 pixels,
+// then interpolates the rest of the way. A single bilinear kernel pass would
+// buffer a float per channel for every target column of every source row,
+// close to 100MB for a phone photo.
+func resize(img image.Image, width, height int) *image.RGBA {
+	for {
+		bounds := img.Bounds()
+		if bounds.Dx() < 2*width || bounds.Dy() < 2*height {
+			break
+		}
+		half := image.NewRGBA(image.Rect(0, 0, bounds.Dx()/2, bounds.Dy()/2))
+		draw.ApproxBiLinear.Scale(half, half.Bounds(), img, bounds, draw.Src, nil)
+		img = half
+	}
+
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.ApproxBiLinear.Scale(resized, resized.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return resized
+}
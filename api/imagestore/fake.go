@@ -0,0 +1,88 @@
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+)
+
+// fakeBaseURL is where the fake pretends to serve images from. Nothing is
+// served there; the fake face service never fetches them.
+const fakeBaseURL = "https://fake-image-store.invalid/image/upload/v1/"
+
+// Fake keeps images in memory. It is for local development and integration
+// tests only.
+type Fake struct {
+	mu     sync.Mutex
+	images map[string]string
+	nextID int
+}
+
+func NewFake() *Fake {
+	return &Fake{images: map[string]string{}}
+}
+
+func (f *Fake) Upload(_ context.Context, file string, params uploader.UploadParams) (*uploader.UploadResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	publicID := params.PublicID
+	if publicID == "" {
+		f.nextID++
+		publicID = fmt.Sprintf("fake_%d", f.nextID)
+	}
+	if params.Folder != "" {
+		publicID = path.Join(params.Folder, publicID)
+	}
+	f.images[publicID] = file
+
+	return &uploader.UploadResult{
+		PublicID:  publicID,
+		URL:       fakeBaseURL + publicID,
+		SecureURL: fakeBaseURL + publicID,
+	}, nil
+}
+
+func (f *Fake) Destroy(_ context.Context, params uploader.DestroyParams) (*uploader.DestroyResult, error) {
+	if f.delete(params.PublicID) {
+		return &uploader.DestroyResult{Result: "ok"}, nil
+	}
+	return &uploader.DestroyResult{Result: "not found"}, nil
+}
+
+func (f *Fake) DeleteAssets(_ context.Context, params admin.DeleteAssetsParams) (*admin.DeleteAssetsResult, error) {
+	result := &admin.DeleteAssetsResult{Deleted: map[string]string{}}
+	for _, publicID := range params.PublicIDs {
+		if f.delete(publicID) {
+			result.Deleted[publicID] = "deleted"
+		} else {
+			result.Deleted[publicID] = "not_found"
+		}
+	}
+	return result, nil
+}
+
+func (f *Fake) SignedURL(publicID string) (string, error) {
+	return fakeBaseURL + publicID, nil
+}
+
+func (f *Fake) delete(publicID string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, found := f.images[publicID]
+	delete(f.images, publicID)
+	return found
+}
+
+func (f *Fake) CheckURL(secureURL string) error {
+	if !strings.HasPrefix(secureURL, fakeBaseURL) {
+		return fmt.Errorf("image URL %q is not a fake store URL", secureURL)
+	}
+	return nil
+}
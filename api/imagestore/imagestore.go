@@ -0,0 +1,101 @@
+// Package imagestore wraps the Cloudinary operations the handlers use, so
+// they can be swapped for an in-memory fake in FAKE_DEPENDENCIES mode.
+package imagestore
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/cloudinary/cloudinary-go/v2/api/admin"
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// ImageStore stores reference and probe images.
+type ImageStore interface {
+	Upload(ctx context.Context, file string, params uploader.UploadParams) (*uploader.UploadResult, error)
+	Destroy(ctx context.Context, params uploader.DestroyParams) (*uploader.DestroyResult, error)
+	DeleteAssets(ctx context.Context, params admin.DeleteAssetsParams) (*admin.DeleteAssetsResult, error)
+
+	// SignedURL returns a signed delivery URL for the image.
+	SignedURL(publicID string) (string, error)
+
+	// CheckURL returns an error unless secureURL is an HTTPS delivery URL
+	// of this store, so a bad upload result is never saved as a reference
+	// verify can't fetch.
+	CheckURL(secureURL string) error
+}
+
+// sharedDeliveryHost serves the images of every Cloudinary cloud.
+const sharedDeliveryHost = "res.cloudinary.com"
+
+// Store is the shared store used by the handlers. It is set up by Init.
+var Store ImageStore
+
+func Init() {
+	if config.App.FakeDependencies {
+		Store = NewFake()
+		return
+	}
+
+	cld, err := cloudinary.New()
+	if err != nil {
+		log.Fatalf("Error: failed to set up Cloudinary: %v", err)
+	}
+	cld.Upload.Client.Transport = serverErrorTransport{base: http.DefaultTransport}
+	Store = &cloudinaryStore{cld: cld}
+}
+
+type cloudinaryStore struct {
+	cld *cloudinary.Cloudinary
+}
+
+// Upload retries transient failures. An upload rejected by Cloudinary is
+// returned as an *APIError rather than only in the result.
+func (s *cloudinaryStore) Upload(ctx context.Context, file string, params uploader.UploadParams) (*uploader.UploadResult, error) {
+	return uploadWithRetry(ctx, func() (*uploader.UploadResult, error) {
+		return s.cld.Upload.Upload(ctx, file, params)
+	})
+}
+
+func (s *cloudinaryStore) Destroy(ctx context.Context, params uploader.DestroyParams) (*uploader.DestroyResult, error) {
+	return s.cld.Upload.Destroy(ctx, params)
+}
+
+func (s *cloudinaryStore) DeleteAssets(ctx context.Context, params admin.DeleteAssetsParams) (*admin.DeleteAssetsResult, error) {
+	return s.cld.Admin.DeleteAssets(ctx, params)
+}
+
+func (s *cloudinaryStore) CheckURL(secureURL string) error {
+	u, err := url.Parse(secureURL)
+	if err != nil {
+		return fmt.Errorf("malformed image URL %q: %w", secureURL, err)
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("image URL %q is not HTTPS", secureURL)
+	}
+	if host := config.App.CloudinaryDeliveryHost; u.Host != host {
+		return fmt.Errorf("image URL %q is not served from %s", secureURL, host)
+	}
+	// Only the shared host puts the cloud name in the path, custom CNAMEs
+	// belong to a single cloud
+	if cloud := s.cld.Config.Cloud.CloudName; u.Host == sharedDeliveryHost && !strings.HasPrefix(u.Path, "/"+cloud+"/") {
+		return fmt.Errorf("image URL %q is not in cloud %s", secureURL, cloud)
+	}
+	return nil
+}
+
+func (s *cloudinaryStore) SignedURL(publicID string) (string, error) {
+	image, err := s.cld.Image(publicID)
+	if err != nil {
+		return "", err
+	}
+	image.Config.URL.Secure = true
+	image.Config.URL.SignURL = true
+	return image.String()
+}
@@ -0,0 +1,44 @@
+package imagestore
+
+import (
+	"testing"
+
+	"github.com/cloudinary/cloudinary-go/v2"
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+func TestCheckURL(t *testing.T) {
+	cld, err := cloudinary.NewFromParams("ourcloud", "key", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &cloudinaryStore{cld: cld}
+
+	tests := []struct {
+		name    string
+		host    string
+		url     string
+		wantErr bool
+	}{
+		{"ours", "res.cloudinary.com", "https://res.cloudinary.com/ourcloud/image/upload/v1/users/a.jpg", false},
+		{"http scheme", "res.cloudinary.com", "http://res.cloudinary.com/ourcloud/image/upload/v1/users/a.jpg", true},
+		{"foreign host", "res.cloudinary.com", "https://evil.example.com/ourcloud/image/upload/v1/users/a.jpg", true},
+		{"foreign cloud path", "res.cloudinary.com", "https://res.cloudinary.com/othercloud/image/upload/v1/users/a.jpg", true},
+		{"cloud name as a prefix", "res.cloudinary.com", "https://res.cloudinary.com/ourcloudx/image/upload/v1/users/a.jpg", true},
+		{"custom CNAME", "images.example.com", "https://images.example.com/image/upload/v1/users/a.jpg", false},
+		{"custom CNAME, foreign host", "images.example.com", "https://res.cloudinary.com/ourcloud/image/upload/v1/users/a.jpg", true},
+		{"empty", "res.cloudinary.com", "", true},
+		{"no host", "res.cloudinary.com", "https://", true},
+		{"no scheme", "res.cloudinary.com", "://res.cloudinary.com/ourcloud/image/upload/v1/users/a.jpg", true},
+		{"control character", "res.cloudinary.com", "https://res.cloudinary.com/ourcloud/image/upload/v1/users/a\n.jpg", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.App.CloudinaryDeliveryHost = tt.host
+			err := store.CheckURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckURL(%q) = %v, want error: %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
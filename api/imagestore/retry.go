@@ -0,0 +1,83 @@
+package imagestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cloudinary/cloudinary-go/v2/api/uploader"
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// APIError is an error Cloudinary answered a request with, such as an
+// invalid image. Retrying won't help.
+type APIError struct {
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return "cloudinary: " + e.Message
+}
+
+// ServerError is a 5xx answer from Cloudinary.
+type ServerError struct {
+	StatusCode int
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("cloudinary: server error %d", e.StatusCode)
+}
+
+// serverErrorTransport turns 5xx responses into errors. The SDK otherwise
+// only reports the error message of the body, whatever the status, which
+// leaves no way to tell transient failures from rejected uploads.
+type serverErrorTransport struct {
+	base http.RoundTripper
+}
+
+func (t serverErrorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode < http.StatusInternalServerError {
+		return resp, err
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &ServerError{StatusCode: resp.StatusCode}
+}
+
+// isTransient reports whether a failed upload is worth retrying: network
+// errors and 5xx answers are, rejected uploads aren't.
+func isTransient(err error) bool {
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// uploadWithRetry calls upload, retrying transient failures up to
+// UPLOAD_RETRIES times with a backoff doubling from UPLOAD_RETRY_BACKOFF.
+func uploadWithRetry(ctx context.Context, upload func() (*uploader.UploadResult, error)) (*uploader.UploadResult, error) {
+	backoff := config.App.UploadRetryBackoff
+	for attempt := 0; ; attempt++ {
+		result, err := upload()
+		if err == nil && result.Error.Message != "" {
+			err = &APIError{Message: result.Error.Message}
+		}
+		if err == nil || attempt >= config.App.UploadRetries || !isTransient(err) {
+			return result, err
+		}
+
+		log.Printf("Warning: Cloudinary upload failed (attempt %d of %d), retrying in %s: %v",
+			attempt+1, config.App.UploadRetries+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,125 @@
+// Package mailer sends the emails the API needs, such as registration
+// confirmation links.
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Default is the mailer used by the handlers, set up by Init.
+var Default Mailer = NopMailer{}
+
+// Init picks the SMTP mailer when SMTP_HOST is set, and the no-op one
+// otherwise (e.g. in development).
+func Init() {
+	if config.App.SMTPHost == "" {
+		log.Println("SMTP_HOST not set, emails will only be logged.")
+		Default = NopMailer{}
+		return
+	}
+	Default = &SMTPMailer{
+		Addr:     net.JoinHostPort(config.App.SMTPHost, strconv.Itoa(config.App.SMTPPort)),
+		Host:     config.App.SMTPHost,
+		Username: config.App.SMTPUsername,
+		Password: config.App.SMTPPassword,
+		From:     config.App.MailFrom,
+		Timeout:  config.App.SMTPTimeout,
+	}
+}
+
+// SMTPMailer sends plain text emails through an SMTP server, upgrading to
+// TLS when it offers STARTTLS and authenticating when a username is set.
+// Timeout bounds each send; zero means no limit.
+type SMTPMailer struct {
+	Addr     string
+	Host     string
+	Username string
+	Password string
+	From     string
+	Timeout  time.Duration
+}
+
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	// Headers come from our own templates, but the address is user input
+	if strings.ContainsAny(to, "\r\n") {
+		return fmt.Errorf("invalid recipient address %q", to)
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	message := "From: " + m.From + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body
+
+	// smtp.SendMail, but with a deadline on the connection
+	conn, err := net.DialTimeout("tcp", m.Addr, m.Timeout)
+	if err != nil {
+		return err
+	}
+	if m.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(m.Timeout))
+	}
+	client, err := smtp.NewClient(conn, m.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.Host}); err != nil {
+			return err
+		}
+	}
+	if auth != nil {
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(m.From); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write([]byte(message)); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// NopMailer logs emails instead of sending them. Only the subject and the
+// recipient, hashed like everywhere else in the logs, are logged: bodies
+// carry confirmation tokens.
+type NopMailer struct{}
+
+func (NopMailer) Send(to, subject, body string) error {
+	log.Printf("Email not sent (no mailer configured): to=%s subject=%q", middleware.LoggedEmail(to), subject)
+	return nil
+}
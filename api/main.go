@@ -1,13 +1,24 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/joho/godotenv"
+	"github.com/kwagmire/facial-verification-api/buildinfo"
+	"github.com/kwagmire/facial-verification-api/config"
 	"github.com/kwagmire/facial-verification-api/db"
 	"github.com/kwagmire/facial-verification-api/handlers"
+	"github.com/kwagmire/facial-verification-api/imagestore"
+	"github.com/kwagmire/facial-verification-api/mailer"
+	"github.com/kwagmire/facial-verification-api/microservice"
+	"github.com/kwagmire/facial-verification-api/middleware"
+	"github.com/kwagmire/facial-verification-api/thresholds"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/cors"
 )
 
@@ -17,26 +28,131 @@ func main() {
 		log.Println("Warning: Could not load .env file. Assuming environment variables are set in the environment.")
 	}
 
+	config.Load()
+	handlers.SetMaintenanceMode(config.App.MaintenanceMode)
+
+	if err := db.ConnectDB(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
 	db.RunMigrations()
 
-	db.ConnectDB()
+	if err := db.SyncUniqueNameIndex(config.App.RequireUniqueName); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	if err := thresholds.Load(); err != nil {
+		log.Fatalf("Error: failed to load thresholds: %v", err)
+	}
+
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handlers.StartRetention(ctx)
+
+	microservice.Init()
+	imagestore.Init()
+	mailer.Init()
+	if config.App.WaitForMicroservice {
+		err := microservice.Service.WaitUntilReady(config.App.MicroserviceWaitAttempts, config.App.MicroserviceWaitInterval)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+	}
 
+	// Routes are registered in their canonical form, without a trailing slash
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("POST /register", handlers.RegisterUser)
-	mux.HandleFunc("POST /verify", handlers.VerifyUser)
+	// Responses carrying verification results or user data must never be
+	// cached, so every such route is wrapped in NoStore. Maintenance mode
+	// pauses them all, but not health checks or the admin API.
+	mux.Handle("POST /register", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.LimitPerIP(handlers.RegisterUser), config.App.RegisterTimeout)))))
+	mux.Handle("POST /register/reserve", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.ReserveEmail, config.App.AdminTimeout)))))
+	mux.Handle("POST /register/confirm", middleware.NoStore(handlers.UnlessMaintenance(handlers.WithTimeout(handlers.ConfirmEmail, config.App.AdminTimeout))))
+	mux.Handle("POST /register/confirm/resend", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.LimitPerIP(handlers.ResendConfirmation), config.App.AdminTimeout)))))
+	mux.Handle("POST /verify", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.LimitPerIP(handlers.VerifyUser), config.App.VerifyTimeout)))))
+	mux.Handle("POST /embed", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.LimitPerIP(handlers.Embed), config.App.VerifyTimeout)))))
+	mux.Handle("POST /verify/id-document", middleware.NoStore(handlers.UnlessMaintenance(handlers.RequireAPIKey(handlers.WithTimeout(handlers.LimitPerIP(handlers.VerifyIDDocument), config.App.VerifyTimeout)))))
+
+	// Per-outcome counts aren't for the public, scrapers send ADMIN_API_KEY
+	// as a bearer token
+	mux.Handle("GET /metrics", handlers.AdminOnly(promhttp.Handler().ServeHTTP))
+	mux.HandleFunc("GET /version", handlers.Version)
+	mux.HandleFunc("GET /health", handlers.Health)
+
+	adminTimeout := config.App.AdminTimeout
+	mux.Handle("POST /admin/users/bulk-delete", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.BulkDeleteUsers), adminTimeout)))
+	mux.Handle("GET /admin/users/{email}/export", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.ExportUser), adminTimeout)))
+	mux.Handle("GET /admin/users/{email}/distance-history", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetDistanceHistory), adminTimeout)))
+	mux.Handle("PUT /admin/users/{email}/face", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.UpdateUserFace), config.App.RegisterTimeout)))
+	mux.Handle("POST /admin/verify/raw", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.RawVerify), config.App.VerifyTimeout)))
+	mux.Handle("POST /admin/cloudinary/remap", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.RemapImageURLs), adminTimeout)))
+	mux.Handle("GET /admin/registrations/failed", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.ListFailedRegistrations), adminTimeout)))
+	mux.Handle("POST /admin/registrations/failed/{id}/retry", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.RetryFailedRegistration), config.App.RegisterTimeout)))
+	mux.Handle("GET /admin/maintenance", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetMaintenance), adminTimeout)))
+	mux.Handle("PUT /admin/maintenance", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.UpdateMaintenance), adminTimeout)))
+	mux.Handle("GET /admin/config", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetConfig), adminTimeout)))
+	mux.Handle("GET /admin/config/thresholds", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetThresholds), adminTimeout)))
+	mux.Handle("PUT /admin/config/thresholds", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.UpdateThresholds), adminTimeout)))
+	mux.Handle("POST /admin/organizations", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.CreateOrganization), adminTimeout)))
+	mux.Handle("GET /admin/organizations/{id}/thresholds", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetOrgThresholds), adminTimeout)))
+	mux.Handle("PUT /admin/organizations/{id}/thresholds", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.UpdateOrgThresholds), adminTimeout)))
+	mux.Handle("POST /admin/organizations/{id}/api-keys", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.CreateAPIKey), adminTimeout)))
+	mux.Handle("GET /admin/api-keys", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.ListAPIKeys), adminTimeout)))
+	mux.Handle("GET /admin/stats", middleware.NoStore(handlers.WithTimeout(handlers.AdminOnly(handlers.GetStats), adminTimeout)))
 
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS", "PATCH"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", handlers.APIKeyHeader},
+		ExposedHeaders:   []string{middleware.RequestIDHeader},
 		AllowCredentials: true,
 	})
 
-	handler := c.Handler(mux)
+	var routes http.Handler = mux
+	if config.App.StripTrailingSlash {
+		routes = middleware.StripTrailingSlash(routes)
+	}
+
+	handler := c.Handler(middleware.RequestID(routes))
+
+	// Register and verify answer 503 under maintenance, so it is skipped then
+	if config.App.RunSelfTest && config.App.MaintenanceMode {
+		log.Println("Self-test skipped: maintenance mode is on.")
+	} else if config.App.RunSelfTest {
+		switch err := runSelfTest(handler); {
+		case err == nil:
+			log.Println("Self-test passed.")
+		case config.App.SelfTestFatal:
+			log.Fatalf("Error: self-test failed: %v", err)
+		default:
+			log.Printf("Warning: self-test failed: %v", err)
+		}
+	}
 	serverPort := ":8080"
 
-	fmt.Printf("Face Recognition API server starting on port %s...", serverPort)
-	log.Fatal(http.ListenAndServe(serverPort, handler))
+	server := newServer(serverPort, handler)
+	go func() {
+		build := buildinfo.Get()
+		log.Printf("Face Recognition API %s (commit %s, built %s, %s) starting on port %s...",
+			build.Version, build.Commit, build.BuildTime, build.GoVersion, serverPort)
+		if err := listen(server); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down, waiting for in-flight requests...")
 
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.App.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: server did not shut down cleanly: %v", err)
+	}
+	if err := handlers.WaitForTasks(shutdownCtx); err != nil {
+		log.Printf("Warning: gave up waiting for background tasks: %v", err)
+	}
+	db.DB.Close()
+	log.Println("Server stopped.")
 }
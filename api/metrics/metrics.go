@@ -0,0 +1,51 @@
+// Package metrics defines the Prometheus metrics exported on /metrics.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// FaceServiceLatency tracks the duration of calls to the face microservice.
+	FaceServiceLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "face_service_request_duration_seconds",
+		Help:    "Duration of calls to the face microservice.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"path", "backend"})
+
+	// FaceServiceSlowCalls counts calls exceeding the latency SLO.
+	FaceServiceSlowCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "face_service_slow_requests_total",
+		Help: "Calls to the face microservice that exceeded the latency SLO.",
+	}, []string{"path", "backend"})
+
+	// FaceServiceInferenceTime tracks the processing time reported by the
+	// microservice itself, which excludes network latency.
+	FaceServiceInferenceTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "face_service_inference_seconds",
+		Help:    "Verification processing time reported by the face microservice.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"model"})
+
+	// VerifyModelLatency tracks verification durations per ensemble model,
+	// "primary" or "ensemble".
+	VerifyModelLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "face_verify_model_duration_seconds",
+		Help:    "Duration of verifications per ensemble model.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+	}, []string{"model"})
+
+	// ActiveVerifications is the number of verify requests in progress.
+	ActiveVerifications = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "active_verifications",
+		Help: "Verify requests currently in progress.",
+	})
+
+	// EnsembleFallbacks counts verifications decided by the primary model
+	// alone because the ensemble model timed out.
+	EnsembleFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "face_ensemble_fallbacks_total",
+		Help: "Verifications that fell back to the primary model after the ensemble model timed out.",
+	})
+)
@@ -0,0 +1,247 @@
+package microservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/metrics"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// FaceService is the face recognition service, implemented by Client and,
+// in FAKE_DEPENDENCIES mode, by Fake.
+type FaceService interface {
+	DetectFace(ctx context.Context, img string, minFaceRatio float64) (*DetectionResponse, error)
+	Verify(ctx context.Context, payload VerifyRequest) (*VerificationResponse, error)
+	Embed(ctx context.Context, img string) (*EmbeddingResponse, error)
+	Healthy(ctx context.Context) error
+	WaitUntilReady(attempts int, interval time.Duration) error
+}
+
+// Service is the shared client used by the handlers. It is set up by Init.
+var Service FaceService
+
+// Ensemble is the client for the alternate model's service, nil unless
+// ENSEMBLE_MICROSERVICE_URLS is set.
+var Ensemble FaceService
+
+// Client talks to the Python face recognition service. When several base
+// URLs are configured they are tried in order (primary, then secondaries)
+// and the client fails over to the next one when a backend is unreachable.
+type Client struct {
+	baseURLs   []string
+	httpClient *http.Client
+}
+
+// StatusError is returned when the microservice answers with a non-200 status.
+type StatusError struct {
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("face service returned status %d: %s", e.StatusCode, redact(e.Body))
+}
+
+// Reasons the microservice gives, in a 400 response, for images it couldn't
+// compare at all.
+const (
+	ReasonNoFace = "no_face_in_probe"
+	// The reference has no usable face, which only happens for references
+	// that weren't checked at enrollment, such as ID documents
+	ReasonNoFaceInReference = "no_face_in_reference"
+	// Given for too small faces by microservice versions predating
+	// ReasonFaceTooSmall on verify
+	ReasonLowQuality = "low_quality"
+
+	// Given when CompareAntiSpoofing found a spoof
+	ReasonSpoofDetected = "spoof_detected"
+
+	// Given by detect-face
+	ReasonNoFaceDetected = "no_face"
+	ReasonMultipleFaces  = "multiple_faces"
+
+	// Given by both detect-face and verify, with the face and image sizes
+	ReasonFaceTooSmall = "face_too_small"
+)
+
+// Error codes the microservice gives for failures other than a rejected
+// face, alongside a free-form detail.
+const (
+	ErrorCodeReferenceUnavailable = "reference_unavailable"
+	ErrorCodeInvalidImage         = "invalid_image"
+	ErrorCodeInternal             = "internal_error"
+)
+
+// ErrorCode returns the error_code from the response body, or "" when the
+// microservice didn't give one.
+func (e *StatusError) ErrorCode() string {
+	var body struct {
+		ErrorCode string `json:"error_code"`
+	}
+	_ = json.Unmarshal(e.Body, &body)
+	return body.ErrorCode
+}
+
+// ErrorDetail is the structured detail of a 400 response. Older or
+// unexpected errors only carry a message, and leave it empty.
+type ErrorDetail struct {
+	Reason    string `json:"reason"`
+	FaceSize  *Size  `json:"face_size,omitempty"`
+	ImageSize *Size  `json:"image_size,omitempty"`
+}
+
+// Detail returns the structured detail from the response body.
+func (e *StatusError) Detail() ErrorDetail {
+	var body struct {
+		Detail ErrorDetail `json:"detail"`
+	}
+	// A plain string detail doesn't unmarshal and leaves it empty
+	_ = json.Unmarshal(e.Body, &body)
+	return body.Detail
+}
+
+// Reason returns the machine-readable reason from the response body, or ""
+// when the microservice didn't give one.
+func (e *StatusError) Reason() string {
+	return e.Detail().Reason
+}
+
+func Init() {
+	if config.App.FakeDependencies {
+		Service = Fake{}
+		return
+	}
+
+	Service = NewClient(config.App.FaceMicroserviceURLs)
+	if len(config.App.EnsembleMicroserviceURLs) > 0 {
+		Ensemble = NewClient(config.App.EnsembleMicroserviceURLs)
+	}
+}
+
+func NewClient(baseURLs []string) *Client {
+	trimmed := make([]string, len(baseURLs))
+	for i, baseURL := range baseURLs {
+		trimmed[i] = strings.TrimRight(baseURL, "/")
+	}
+
+	transport, err := newTransport()
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	return &Client{
+		baseURLs: trimmed,
+		httpClient: &http.Client{
+			Timeout:   config.App.FaceMicroserviceTimeout,
+			Transport: transport,
+		},
+	}
+}
+
+// post sends payload as JSON to path and decodes the response into out,
+// failing over to the next backend when one can't be reached.
+func (c *Client) post(ctx context.Context, path string, payload interface{}, out interface{}) error {
+	// Marshal the payload struct into JSON bytes
+	jsonPayload, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling json: %w", err)
+	}
+
+	requestID := middleware.GetRequestID(ctx)
+	for i, baseURL := range c.baseURLs {
+		start := time.Now()
+		err = c.postTo(ctx, baseURL+path, jsonPayload, out)
+		observeLatency(requestID, baseURL, path, time.Since(start))
+		if err == nil {
+			log.Printf("request_id=%s backend=%s path=%s: face service call succeeded", requestID, baseURL, path)
+			return nil
+		}
+		if !isFailoverError(err) || i == len(c.baseURLs)-1 {
+			log.Printf("request_id=%s backend=%s path=%s: face service call failed: %v", requestID, baseURL, path, err)
+			return err
+		}
+		log.Printf("request_id=%s backend=%s path=%s: backend unavailable, failing over: %v", requestID, baseURL, path, err)
+	}
+
+	return errors.New("no face service URL configured")
+}
+
+func (c *Client) postTo(ctx context.Context, url string, jsonPayload []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(jsonPayload))
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := middleware.GetRequestID(ctx); requestID != "" {
+		req.Header.Set(middleware.RequestIDHeader, requestID)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return &StatusError{StatusCode: resp.StatusCode, Body: bodyBytes}
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+	if err = json.Unmarshal(bodyBytes, out); err != nil {
+		return &ContractError{Reason: "invalid JSON: " + err.Error(), Body: bodyBytes}
+	}
+	return validateResponse(bodyBytes, out)
+}
+
+// observeLatency records the duration of a call and warns when it exceeds the
+// configured SLO, so degradation can be alerted on before calls start failing.
+func observeLatency(requestID string, backend string, path string, elapsed time.Duration) {
+	metrics.FaceServiceLatency.WithLabelValues(path, backend).Observe(elapsed.Seconds())
+
+	slo := config.App.FaceMicroserviceSLO
+	if slo > 0 && elapsed > slo {
+		metrics.FaceServiceSlowCalls.WithLabelValues(path, backend).Inc()
+		log.Printf("request_id=%s backend=%s path=%s: slow face service call took %s (SLO %s)", requestID, backend, path, elapsed, slo)
+	}
+}
+
+// isFailoverError reports whether err means the backend is unreachable or
+// overloaded, in which case the next backend should be tried.
+func isFailoverError(err error) bool {
+	var contractErr *ContractError
+	if errors.As(err, &contractErr) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	// The request itself was cancelled or ran out of time, another
+	// backend won't do any better
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	// Any other error happened before a response was received
+	return true
+}
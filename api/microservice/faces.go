@@ -0,0 +1,145 @@
+package microservice
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/metrics"
+	"github.com/kwagmire/facial-verification-api/middleware"
+)
+
+// This struct matches the JSON payload for the microservice detect-face endpoint
+type detectFacePayload struct {
+	Img          string  `json:"img"`
+	MinFaceRatio float64 `json:"min_face_ratio"`
+}
+
+// DetectionResponse matches the JSON response from the detect-face endpoint.
+// Older models may leave out the anti-spoofing verdict.
+type DetectionResponse struct {
+	Status     string    `json:"status"`
+	IsReal     *bool     `json:"is_real"`
+	AntiSScore *float64  `json:"antispoof_score"`
+	HeadPose   *HeadPose `json:"head_pose,omitempty"`
+	FaceSize   *Size     `json:"face_size,omitempty"`
+	ImageSize  *Size     `json:"image_size,omitempty"`
+}
+
+// Size is the size in pixels of a detected face or of the whole image.
+type Size struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+// HeadPose holds the estimated head rotation in degrees. Zero means facing
+// the camera straight on.
+// Older services don't report pitch, which is then zero.
+type HeadPose struct {
+	Yaw   float64 `json:"yaw"`
+	Pitch float64 `json:"pitch"`
+	Roll  float64 `json:"roll"`
+}
+
+// This struct matches the JSON payload for the microservice embed endpoint
+type embedPayload struct {
+	Img string `json:"img"`
+}
+
+// EmbeddingResponse matches the JSON response from the embed endpoint.
+// Embeddings are only comparable between identical models, using
+// DistanceMetric.
+type EmbeddingResponse struct {
+	Embedding      []float64 `json:"embedding"`
+	Model          string    `json:"model,omitempty"`
+	ModelVersion   string    `json:"model_version,omitempty"`
+	DistanceMetric string    `json:"distance_metric,omitempty"`
+}
+
+// VerifyRequest matches the JSON payload for the microservice verify endpoint
+type VerifyRequest struct {
+	RegImg       string `json:"regimg"`
+	VerImg       string `json:"verimg"`
+	AntiSpoofing bool   `json:"anti_spoofing"`
+
+	// MinFaceRatio is the smallest face height, as a fraction of the probe
+	// height, the microservice accepts.
+	MinFaceRatio float64 `json:"min_face_ratio"`
+
+	// CompareAntiSpoofing has the comparison itself reject a spoof of either
+	// image. Left off for references that are photos of a photo, like ID
+	// documents.
+	CompareAntiSpoofing bool `json:"compare_anti_spoofing"`
+}
+
+// VerificationResponse matches the JSON response from the verify endpoint
+type VerificationResponse struct {
+	IsMatch   bool    `json:"is_match"`
+	Distance  float64 `json:"distance"`
+	Threshold float64 `json:"threshold"`
+	Time      float64 `json:"time"`
+	Model     string  `json:"model,omitempty"`
+
+	// ModelVersion falls back to MODEL_VERSION when the microservice
+	// doesn't report one.
+	ModelVersion string `json:"model_version,omitempty"`
+
+	// Only set when anti-spoofing ran on the probe image
+	ProbeIsReal         *bool    `json:"probe_is_real,omitempty"`
+	ProbeAntiSpoofScore *float64 `json:"probe_antispoof_score,omitempty"`
+
+	ProbeFaceSize *Size `json:"probe_face_size,omitempty"`
+}
+
+// DetectFace checks that the Base64 image contains exactly one real face,
+// at least minFaceRatio of the image height.
+func (c *Client) DetectFace(ctx context.Context, img string, minFaceRatio float64) (*DetectionResponse, error) {
+	var detection DetectionResponse
+	payload := detectFacePayload{Img: img, MinFaceRatio: minFaceRatio}
+	if err := c.post(ctx, "/detect-face", payload, &detection); err != nil {
+		return nil, err
+	}
+	return &detection, nil
+}
+
+// Verify compares the reference image with the probe image.
+func (c *Client) Verify(ctx context.Context, payload VerifyRequest) (*VerificationResponse, error) {
+	var verification VerificationResponse
+	if err := c.post(ctx, "/verify", payload, &verification); err != nil {
+		return nil, err
+	}
+	if verification.ModelVersion == "" {
+		verification.ModelVersion = config.App.ModelVersion
+	}
+	observeInferenceTime(ctx, &verification)
+	return &verification, nil
+}
+
+// Embed returns the face embedding of the Base64 image, which must contain
+// exactly one face.
+func (c *Client) Embed(ctx context.Context, img string) (*EmbeddingResponse, error) {
+	var embedding EmbeddingResponse
+	if err := c.post(ctx, "/embed", embedPayload{Img: img}, &embedding); err != nil {
+		return nil, err
+	}
+	if embedding.ModelVersion == "" {
+		embedding.ModelVersion = config.App.ModelVersion
+	}
+	return &embedding, nil
+}
+
+// observeInferenceTime records the processing time the microservice reports
+// for a verification and warns when it is unusually high.
+func observeInferenceTime(ctx context.Context, verification *VerificationResponse) {
+	model := verification.Model
+	if model == "" {
+		model = "unknown"
+	}
+	metrics.FaceServiceInferenceTime.WithLabelValues(model).Observe(verification.Time)
+
+	limit := config.App.InferenceTimeWarning
+	if elapsed := time.Duration(verification.Time * float64(time.Second)); limit > 0 && elapsed > limit {
+		log.Printf("request_id=%s model=%s: slow inference took %s (warning at %s)", middleware.GetRequestID(ctx), model, elapsed, limit)
+	}
+}
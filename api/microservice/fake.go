@@ -0,0 +1,52 @@
+package microservice
+
+import (
+	"context"
+	"time"
+)
+
+// Fake answers like a healthy face service that finds one real face in
+// every image and matches every pair. It is for local development and
+// integration tests only.
+type Fake struct{}
+
+func (Fake) DetectFace(ctx context.Context, img string, minFaceRatio float64) (*DetectionResponse, error) {
+	isReal, score := true, 1.0
+	return &DetectionResponse{
+		Status:     "success",
+		IsReal:     &isReal,
+		AntiSScore: &score,
+		HeadPose:   &HeadPose{},
+	}, nil
+}
+
+func (Fake) Verify(ctx context.Context, payload VerifyRequest) (*VerificationResponse, error) {
+	verification := &VerificationResponse{
+		IsMatch:   true,
+		Distance:  0.1,
+		Threshold: 0.4,
+		Model:     "fake",
+	}
+	if payload.AntiSpoofing {
+		isReal, score := true, 1.0
+		verification.ProbeIsReal = &isReal
+		verification.ProbeAntiSpoofScore = &score
+	}
+	return verification, nil
+}
+
+func (Fake) Embed(ctx context.Context, img string) (*EmbeddingResponse, error) {
+	return &EmbeddingResponse{
+		Embedding:      []float64{0.5, 0.5, 0.5, 0.5},
+		Model:          "fake",
+		DistanceMetric: "cosine",
+	}, nil
+}
+
+func (Fake) Healthy(ctx context.Context) error {
+	return nil
+}
+
+func (Fake) WaitUntilReady(attempts int, interval time.Duration) error {
+	return nil
+}
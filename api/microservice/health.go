@@ -0,0 +1,59 @@
+package microservice
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Healthy reports whether at least one backend answers its health endpoint.
+func (c *Client) Healthy(ctx context.Context) error {
+	var lastErr error
+	for _, baseURL := range c.baseURLs {
+		lastErr = c.checkHealth(ctx, baseURL)
+		if lastErr == nil {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (c *Client) checkHealth(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s/health returned status %d", baseURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// WaitUntilReady polls the health endpoint until a backend is ready or the
+// attempts run out, so traffic isn't accepted before the model is loaded.
+func (c *Client) WaitUntilReady(attempts int, interval time.Duration) error {
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := c.Healthy(ctx)
+		cancel()
+		if err == nil {
+			log.Printf("Face microservice is ready (attempt %d/%d).", attempt, attempts)
+			return nil
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("face microservice not ready after %d attempts: %w", attempt, err)
+		}
+
+		log.Printf("Waiting for face microservice (attempt %d/%d): %v", attempt, attempts, err)
+		time.Sleep(interval)
+	}
+}
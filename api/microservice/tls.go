@@ -0,0 +1,48 @@
+package microservice
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// newTransport returns the transport used to reach the microservice. It
+// trusts MICROSERVICE_CA_FILE on top of the system roots, e.g. for a
+// staging deployment behind a self-signed certificate.
+func newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.App.MicroserviceCAFile == "" && !config.App.MicroserviceInsecureSkipVerify {
+		return transport, nil
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.App.MicroserviceCAFile != "" {
+		pem, err := os.ReadFile(config.App.MicroserviceCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MICROSERVICE_CA_FILE: %w", err)
+		}
+
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MICROSERVICE_CA_FILE %s", config.App.MicroserviceCAFile)
+		}
+		tlsConfig.RootCAs = roots
+	}
+
+	if config.App.MicroserviceInsecureSkipVerify {
+		log.Println("WARNING: MICROSERVICE_INSECURE_SKIP_VERIFY is set, the face microservice's TLS certificate is NOT verified. Never use this outside local testing.")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
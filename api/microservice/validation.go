@@ -0,0 +1,116 @@
+package microservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// ContractError is returned when the microservice answers 200 but the body
+// doesn't match the response contract (missing fields, impossible values).
+type ContractError struct {
+	Reason string
+	Body   []byte
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("unexpected face service response: %s (body: %s)", e.Reason, redact(e.Body))
+}
+
+// validatedResponse is implemented by responses that can check their own
+// decoded values.
+type validatedResponse interface {
+	requiredFields() []string
+	validate() error
+}
+
+func (d *DetectionResponse) requiredFields() []string {
+	return []string{"status"}
+}
+
+func (d *DetectionResponse) validate() error {
+	if d.Status != "success" {
+		return fmt.Errorf("status is %q", d.Status)
+	}
+	if d.AntiSScore != nil {
+		return checkScore("antispoof_score", *d.AntiSScore)
+	}
+	return nil
+}
+
+func (e *EmbeddingResponse) requiredFields() []string {
+	return []string{"embedding"}
+}
+
+func (e *EmbeddingResponse) validate() error {
+	if len(e.Embedding) == 0 {
+		return fmt.Errorf("embedding is empty")
+	}
+	return nil
+}
+
+func (v *VerificationResponse) requiredFields() []string {
+	return []string{"is_match", "distance", "threshold", "time"}
+}
+
+func (v *VerificationResponse) validate() error {
+	if v.Distance < 0 {
+		return fmt.Errorf("distance is negative (%v)", v.Distance)
+	}
+	if v.Threshold <= 0 {
+		return fmt.Errorf("threshold is not positive (%v)", v.Threshold)
+	}
+	if v.Time < 0 {
+		return fmt.Errorf("time is negative (%v)", v.Time)
+	}
+	if v.ProbeAntiSpoofScore != nil {
+		return checkScore("probe_antispoof_score", *v.ProbeAntiSpoofScore)
+	}
+	return nil
+}
+
+func checkScore(field string, score float64) error {
+	if score < 0 || score > 1 {
+		return fmt.Errorf("%s is out of range [0, 1] (%v)", field, score)
+	}
+	return nil
+}
+
+// validateResponse checks body, already decoded into out, against the
+// response contract.
+func validateResponse(body []byte, out interface{}) error {
+	response, ok := out.(validatedResponse)
+	if !ok {
+		return nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return &ContractError{Reason: "body is not a JSON object", Body: body}
+	}
+	for _, field := range response.requiredFields() {
+		if value, ok := fields[field]; !ok || string(value) == "null" {
+			return &ContractError{Reason: "missing field " + field, Body: body}
+		}
+	}
+
+	if err := response.validate(); err != nil {
+		return &ContractError{Reason: err.Error(), Body: body}
+	}
+	return nil
+}
+
+// Long runs of Base64 characters are most likely image data.
+var base64Run = regexp.MustCompile(`[A-Za-z0-9+/=]{200,}`)
+
+const maxLoggedBodyLength = 1024
+
+// redact makes a response body safe to log: image data is elided and the
+// body is truncated.
+func redact(body []byte) string {
+	redacted := base64Run.ReplaceAllString(string(body), "[redacted]")
+	if len(redacted) > maxLoggedBodyLength {
+		redacted = redacted[:maxLoggedBodyLength] + "...(truncated)"
+	}
+	return redacted
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import "net/http"
+
+// NoStore forbids clients and proxies from caching the responses of next.
+// It is applied per route, to everything returning verification results or
+// user data; routes serving static content (such as an API spec) should be
+// left without it so they stay cacheable.
+func NoStore(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Pragma", "no-cache")
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/kwagmire/facial-verification-api/config"
+)
+
+// requestLogger writes one JSON object per line, the format our log
+// analytics ingests.
+var requestLogger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// Logger returns the structured logger for the request in ctx, tagged with
+// its request ID.
+func Logger(ctx context.Context) *slog.Logger {
+	return requestLogger.With("request_id", GetRequestID(ctx))
+}
+
+// logEmailKey keys the hashes of logged emails, as plain hashes of email
+// addresses are reversed by hashing lists of known addresses. Without
+// EMAIL_HASH_SECRET a random key is used, so hashes only match within one
+// run of one instance.
+var logEmailKey = sync.OnceValue(func() []byte {
+	if config.App.EmailHashSecret != "" {
+		return []byte(config.App.EmailHashSecret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+})
+
+// LoggedEmail returns email as it should appear in logs: a short keyed hash
+// unless LOG_PLAIN_EMAILS is enabled.
+func LoggedEmail(email string) string {
+	if config.App.LogPlainEmails {
+		return email
+	}
+	mac := hmac.New(sha256.New, logEmailKey())
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
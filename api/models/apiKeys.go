@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+type CreateOrganizationPayload struct {
+	Name string `json:"name"`
+}
+
+type CreateAPIKeyPayload struct {
+	Name string `json:"name"`
+}
+
+type APIKeyUsage struct {
+	ID           int        `json:"id"`
+	OrgID        int        `json:"org_id"`
+	Name         string     `json:"name"`
+	RequestCount int64      `json:"request_count"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
+	Revoked      bool       `json:"revoked"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
@@ -1,13 +1,75 @@
 package models
 
+import "time"
+
 type RegisterUserPayload struct {
 	Email        string `json:"email"`
 	FirstName    string `json:"first_name"`
 	LastName     string `json:"last_name"`
 	EncodedImage string `json:"facial_image"` // This will hold the Base64 string
+
+	// Required when the email was reserved with /register/reserve
+	ReservationToken string `json:"reservation_token"`
+}
+
+type ReserveEmailPayload struct {
+	Email string `json:"email"`
 }
 
 type VerifyUserPayload struct {
+	Email        string `json:"email"`
+	UserID       *int   `json:"user_id"` // Alternative to Email, exactly one is required
+	EncodedImage string `json:"facial_image"`
+
+	// Alternative to EncodedImage: a burst of frames, of which the best
+	// matching one is used
+	EncodedImages []string `json:"facial_images"`
+
+	// Reference image URL held by the client, used instead of looking the
+	// user up when ALLOW_INLINE_REFERENCE is on
+	RegImageURL string `json:"reg_image_url"`
+}
+
+// EmbedPayload carries the Base64 capture to compute the embedding of.
+type EmbedPayload struct {
+	EncodedImage string `json:"facial_image"`
+}
+
+// VerifyIDDocumentPayload carries the photo of an identity document and the
+// live selfie to match against it, both Base64.
+type VerifyIDDocumentPayload struct {
+	DocumentImage string `json:"document_image"`
+	EncodedImage  string `json:"facial_image"`
+}
+
+type RawVerifyPayload struct {
 	Email        string `json:"email"`
 	EncodedImage string `json:"facial_image"`
 }
+
+type UpdateFacePayload struct {
+	EncodedImage string `json:"facial_image"`
+}
+
+type ConfirmEmailPayload struct {
+	Token string `json:"token"`
+}
+
+type ResendConfirmationPayload struct {
+	Email string `json:"email"`
+}
+
+// BulkDeleteUsersPayload selects users matching all the set filters.
+type BulkDeleteUsersPayload struct {
+	OrgID         *int       `json:"org_id"`
+	CreatedBefore *time.Time `json:"created_before"`
+	Emails        []string   `json:"emails"`
+	Confirm       bool       `json:"confirm"`
+}
+
+// RemapImageURLsPayload replaces the From prefix of stored image URLs with To.
+type RemapImageURLsPayload struct {
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Confirm bool   `json:"confirm"`
+}
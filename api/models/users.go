@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+type UserProfile struct {
+	ID        int       `json:"id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	CreatedAt time.Time `json:"created_at"`
+	ImageURL  string    `json:"image_url,omitempty"`
+
+	EnrollmentQuality *int `json:"enrollment_quality,omitempty"`
+}
+
+type VerificationAttempt struct {
+	Outcome   string   `json:"outcome"`
+	IsMatch   *bool    `json:"is_match,omitempty"`
+	Distance  *float64 `json:"distance,omitempty"`
+	Threshold *float64 `json:"threshold,omitempty"`
+	ClientIP  string   `json:"client_ip,omitempty"`
+
+	ModelVersion string `json:"model_version,omitempty"`
+	APIVersion   string `json:"api_version,omitempty"`
+	EnrollmentID string `json:"enrollment_id,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// UserExport bundles everything held about a user, for data subject access
+// requests.
+type UserExport struct {
+	Profile              UserProfile           `json:"profile"`
+	VerificationAttempts []VerificationAttempt `json:"verification_attempts"`
+	ExportedAt           time.Time             `json:"exported_at"`
+}
+
+type UserStats struct {
+	TotalUsers         int `json:"total_users"`
+	ActiveUsers30d     int `json:"active_users_30d"`
+	RegistrationsToday int `json:"registrations_today"`
+	VerificationsToday int `json:"verifications_today"`
+}
+
+type DistancePoint struct {
+	Distance  float64   `json:"distance"`
+	Threshold float64   `json:"threshold"`
+	IsMatch   bool      `json:"is_match"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,85 @@
+// Package resulttoken issues and validates short-lived signed verification
+// results. A downstream party holding the shared secret (e.g. a door lock
+// controller) can check a token offline, so a compromised client can't forge
+// a match.
+//
+// A token is base64url(claims JSON) + "." + base64url(HMAC-SHA256 of the
+// first part), both without padding.
+package resulttoken
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMalformed    = errors.New("malformed result token")
+	ErrBadSignature = errors.New("invalid result token signature")
+	ErrExpired      = errors.New("result token expired")
+)
+
+// Claims is the signed content of a token. Times are Unix seconds.
+type Claims struct {
+	UserID    int   `json:"user_id"`
+	IsMatch   bool  `json:"is_match"`
+	IssuedAt  int64 `json:"iat"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// Issue signs a result for userID that is valid for ttl from now.
+func Issue(secret []byte, userID int, isMatch bool, now time.Time, ttl time.Duration) (string, error) {
+	payload, err := json.Marshal(Claims{
+		UserID:    userID,
+		IsMatch:   isMatch,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sign(secret, encoded)), nil
+}
+
+// Validate checks the signature and expiry of token and returns its claims.
+// Callers still have to check IsMatch themselves.
+func Validate(secret []byte, token string, now time.Time) (*Claims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrMalformed
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	if !hmac.Equal(got, sign(secret, encoded)) {
+		return nil, ErrBadSignature
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpired
+	}
+	return &claims, nil
+}
+
+func sign(secret []byte, encoded string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/handlers"
+)
+
+// runSelfTest registers the SELFTEST_EMAIL identity, if it isn't already,
+// and verifies it, through the full handler stack. It catches bad Cloudinary
+// credentials or an unreachable face service at deploy time rather than on
+// the first real request.
+func runSelfTest(handler http.Handler) error {
+	enrollment, probe, err := selfTestImages()
+	if err != nil {
+		return err
+	}
+
+	status, body := selfTestRequest(handler, "/register", map[string]string{
+		"email":        config.App.SelfTestEmail,
+		"first_name":   "Self",
+		"last_name":    "Test",
+		"facial_image": enrollment,
+	})
+	// Already registered on a previous boot
+	if status != http.StatusCreated && !(status == http.StatusConflict && body["code"] == "EMAIL_EXISTS") {
+		return fmt.Errorf("register returned %d: %v", status, body)
+	}
+
+	// Nobody reads the confirmation email sent to it
+	if config.App.RequireEmailConfirmation {
+		if err := handlers.MarkEmailConfirmed(config.App.SelfTestEmail); err != nil {
+			return fmt.Errorf("confirming the self-test email: %w", err)
+		}
+	}
+
+	status, body = selfTestRequest(handler, "/verify", map[string]string{
+		"email":        config.App.SelfTestEmail,
+		"facial_image": probe,
+	})
+	if status != http.StatusOK || body["is_match"] != true {
+		return fmt.Errorf("verify returned %d: %v", status, body)
+	}
+	return nil
+}
+
+// selfTestImages returns SELFTEST_IMAGE and SELFTEST_PROBE_IMAGE as Base64.
+// The fake face service accepts anything, so an image is made up for both
+// when neither is set.
+func selfTestImages() (string, string, error) {
+	if config.App.SelfTestImage == "" && config.App.SelfTestProbeImage == "" && config.App.FakeDependencies {
+		image, err := madeUpImage()
+		return image, image, err
+	}
+	if config.App.SelfTestImage == "" || config.App.SelfTestProbeImage == "" {
+		return "", "", errors.New("SELFTEST_IMAGE and SELFTEST_PROBE_IMAGE, two photos of the same person, are required unless FAKE_DEPENDENCIES is on")
+	}
+
+	enrollment, err := readSelfTestImage("SELFTEST_IMAGE", config.App.SelfTestImage)
+	if err != nil {
+		return "", "", err
+	}
+	probe, err := readSelfTestImage("SELFTEST_PROBE_IMAGE", config.App.SelfTestProbeImage)
+	if err != nil {
+		return "", "", err
+	}
+	return enrollment, probe, nil
+}
+
+func readSelfTestImage(name, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading %s: %w", name, err)
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+// madeUpImage returns a gradient JPEG as Base64.
+func madeUpImage() (string, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func selfTestRequest(handler http.Handler, path string, payload map[string]string) (int, map[string]interface{}) {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if config.App.SelfTestAPIKey != "" {
+		req.Header.Set(handlers.APIKeyHeader, config.App.SelfTestAPIKey)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var response map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec.Code, response
+}
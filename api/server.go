@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newServer sets up the HTTP server with the keep-alive tuning from config.
+// Served over TLS, clients get HTTP/2 through ALPN; in plaintext behind a
+// proxy, ENABLE_H2C lets them speak HTTP/2 without TLS.
+func newServer(addr string, handler http.Handler) *http.Server {
+	if config.App.EnableH2C && !config.App.ServesTLS() {
+		handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: config.App.IdleTimeout})
+	}
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: config.App.ReadHeaderTimeout,
+		IdleTimeout:       config.App.IdleTimeout,
+		// h2 must stay in NextProtos for ListenAndServeTLS to negotiate it
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+	}
+}
+
+// listen serves over TLS when a certificate is configured.
+func listen(server *http.Server) error {
+	if config.App.ServesTLS() {
+		return server.ListenAndServeTLS(config.App.TLSCertFile, config.App.TLSKeyFile)
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,139 @@
+package thresholds
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"sync/atomic"
+
+	"github.com/kwagmire/facial-verification-api/db"
+)
+
+// ErrOrganizationNotFound is returned when overriding the thresholds of an
+// organization that doesn't exist.
+var ErrOrganizationNotFound = errors.New("organization not found")
+
+// Override holds an organization's own thresholds, e.g. a stricter match
+// threshold for a bank than for a gym. Unset fields fall back to the global
+// thresholds.
+type Override struct {
+	AntiSpoofMin    *float64 `json:"antispoof_min,omitempty"`
+	MatchThreshold  *float64 `json:"match_threshold,omitempty"`
+	UncertaintyBand *float64 `json:"uncertainty_band,omitempty"`
+}
+
+func (o Override) isEmpty() bool {
+	return o.AntiSpoofMin == nil && o.MatchThreshold == nil && o.UncertaintyBand == nil
+}
+
+// apply returns thresholds with the override's fields replaced.
+func (o Override) apply(thresholds Thresholds) Thresholds {
+	if o.AntiSpoofMin != nil {
+		thresholds.AntiSpoofMin = *o.AntiSpoofMin
+	}
+	if o.MatchThreshold != nil {
+		thresholds.MatchThreshold = *o.MatchThreshold
+	}
+	if o.UncertaintyBand != nil {
+		thresholds.UncertaintyBand = *o.UncertaintyBand
+	}
+	return thresholds
+}
+
+// overrides maps organization IDs to their override. The map is replaced,
+// never modified, once stored.
+var overrides atomic.Pointer[map[int]Override]
+
+// loadOverrides reads the organizations' overrides.
+func loadOverrides() error {
+	rows, err := db.DB.Query(`SELECT id, thresholds FROM organizations WHERE thresholds IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	loaded := map[int]Override{}
+	for rows.Next() {
+		var orgID int
+		var value []byte
+		if err := rows.Scan(&orgID, &value); err != nil {
+			return err
+		}
+		var override Override
+		if err := json.Unmarshal(value, &override); err != nil {
+			return err
+		}
+		loaded[orgID] = override
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	overrides.Store(&loaded)
+	return nil
+}
+
+// ForOrg returns the thresholds in effect for an organization: the global
+// ones with its override applied. A nil orgID (callers without an API key)
+// gets the global thresholds.
+func ForOrg(orgID *int) Thresholds {
+	thresholds := Get()
+	if orgID == nil {
+		return thresholds
+	}
+	if override, ok := GetOverride(*orgID); ok {
+		return override.apply(thresholds)
+	}
+	return thresholds
+}
+
+// GetOverride returns the organization's override, if it has one.
+func GetOverride(orgID int) (Override, bool) {
+	loaded := overrides.Load()
+	if loaded == nil {
+		return Override{}, false
+	}
+	override, ok := (*loaded)[orgID]
+	return override, ok
+}
+
+// UpdateOverride validates, persists and applies an organization's
+// override, replacing the previous one. An empty override removes it.
+func UpdateOverride(orgID int, override Override) error {
+	if err := override.apply(Thresholds{}).Validate(); err != nil {
+		return err
+	}
+
+	var value []byte
+	if !override.isEmpty() {
+		var err error
+		if value, err = json.Marshal(override); err != nil {
+			return err
+		}
+	}
+
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	result, err := db.DB.Exec(`UPDATE organizations SET thresholds = $1 WHERE id = $2`, value, orgID)
+	if err != nil {
+		return err
+	}
+	if updated, err := result.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return ErrOrganizationNotFound
+	}
+
+	updatedOverrides := map[int]Override{}
+	if loaded := overrides.Load(); loaded != nil {
+		updatedOverrides = maps.Clone(*loaded)
+	}
+	if override.isEmpty() {
+		delete(updatedOverrides, orgID)
+	} else {
+		updatedOverrides[orgID] = override
+	}
+	overrides.Store(&updatedOverrides)
+	return nil
+}
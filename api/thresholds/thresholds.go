@@ -0,0 +1,121 @@
+// Package thresholds holds the decision thresholds that can be tuned at
+// runtime through the admin API. Values are persisted in the settings table
+// so they survive restarts.
+package thresholds
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kwagmire/facial-verification-api/config"
+	"github.com/kwagmire/facial-verification-api/db"
+)
+
+const settingsKey = "thresholds"
+
+type Thresholds struct {
+	// AntiSpoofMin rejects faces whose anti-spoof score is below it. Zero
+	// leaves the decision to the microservice's is_real verdict.
+	AntiSpoofMin float64 `json:"antispoof_min"`
+
+	// MatchThreshold overrides the microservice's distance threshold when
+	// positive.
+	MatchThreshold float64 `json:"match_threshold"`
+
+	// UncertaintyBand flags verifications whose distance is within this
+	// margin of the threshold as uncertain.
+	UncertaintyBand float64 `json:"uncertainty_band"`
+}
+
+var (
+	current atomic.Pointer[Thresholds]
+
+	// updateMu serializes updates so the database and memory stay in sync
+	updateMu sync.Mutex
+)
+
+// Load initializes the thresholds from the settings table, falling back to
+// the values from the environment.
+func Load() error {
+	thresholds := Thresholds{
+		AntiSpoofMin:    config.App.AntiSpoofMin,
+		MatchThreshold:  config.App.MatchThreshold,
+		UncertaintyBand: config.App.UncertaintyBand,
+	}
+
+	var value []byte
+	err := db.DB.QueryRow(`SELECT value FROM settings WHERE key = $1`, settingsKey).Scan(&value)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		if err = json.Unmarshal(value, &thresholds); err != nil {
+			return err
+		}
+	}
+
+	current.Store(&thresholds)
+	return loadOverrides()
+}
+
+// Get returns the global thresholds currently in effect. Requests from an
+// organization should use ForOrg.
+func Get() Thresholds {
+	if thresholds := current.Load(); thresholds != nil {
+		return *thresholds
+	}
+	return Thresholds{}
+}
+
+// Modify applies change to the current thresholds, then validates, persists
+// and applies the result. It all happens under one lock, so concurrent
+// partial updates don't overwrite each other's fields.
+func Modify(change func(*Thresholds) error) (Thresholds, error) {
+	updateMu.Lock()
+	defer updateMu.Unlock()
+
+	thresholds := Get()
+	if err := change(&thresholds); err != nil {
+		return Thresholds{}, err
+	}
+	if err := thresholds.Validate(); err != nil {
+		return Thresholds{}, err
+	}
+
+	value, err := json.Marshal(thresholds)
+	if err != nil {
+		return Thresholds{}, err
+	}
+
+	query := `
+		INSERT INTO settings (key, value, updated_at)
+		VALUES ($1, $2, now())
+		ON CONFLICT (key) DO UPDATE
+			SET value = EXCLUDED.value, updated_at = now()`
+	if _, err = db.DB.Exec(query, settingsKey, value); err != nil {
+		return Thresholds{}, err
+	}
+
+	current.Store(&thresholds)
+	return thresholds, nil
+}
+
+// ValidationError reports thresholds that are out of range.
+type ValidationError struct {
+	error
+}
+
+func (t Thresholds) Validate() error {
+	switch {
+	case t.AntiSpoofMin < 0 || t.AntiSpoofMin > 1:
+		return ValidationError{errors.New("antispoof_min must be between 0 and 1")}
+	case t.MatchThreshold < 0:
+		return ValidationError{errors.New("match_threshold must not be negative")}
+	case t.UncertaintyBand < 0:
+		return ValidationError{errors.New("uncertainty_band must not be negative")}
+	}
+	return nil
+}
@@ -1,22 +1,53 @@
 import logging
+import os
+from importlib.metadata import version
 from pydantic import BaseModel
 from deepface import DeepFace
-from fastapi import FastAPI, HTTPException
+from fastapi import FastAPI, HTTPException, Request
+from fastapi.responses import JSONResponse
 import uvicorn
 import numpy as np
 import requests
 import cv2
 import base64
+import math
 
 # --- Setup ---
 app = FastAPI(title="Face Verification API")
 logging.basicConfig(level=logging.INFO)
 logger = logging.getLogger(__name__)
 
+# --- Request tracing ---
+@app.middleware("http")
+async def log_request_id(request: Request, call_next):
+    """Logs the caller's X-Request-ID so logs can be correlated with the Go API."""
+    request_id = request.headers.get("X-Request-ID", "-")
+    logger.info(f"request_id={request_id} {request.method} {request.url.path}")
+    response = await call_next(request)
+    response.headers["X-Request-ID"] = request_id
+    logger.info(f"request_id={request_id} completed with status {response.status_code}")
+    return response
+
+# --- Structured errors ---
+class ServiceError(HTTPException):
+    """An HTTPException with a machine-readable error_code the API maps to its own codes."""
+    def __init__(self, status_code: int, error_code: str, detail):
+        super().__init__(status_code=status_code, detail=detail)
+        self.error_code = error_code
+
+@app.exception_handler(ServiceError)
+async def service_error_handler(request: Request, exc: ServiceError):
+    return JSONResponse(status_code=exc.status_code, content={"error_code": exc.error_code, "detail": exc.detail})
+
 # --- Model & Constants (Same as before) ---
-FACE_MODEL = "ArcFace"
+# Overridable so a second instance can serve an alternate model for ensembles
+FACE_MODEL = os.environ.get("FACE_MODEL", "ArcFace")
+# Reported with every verdict so the API can record which model judged it
+MODEL_VERSION = os.environ.get("MODEL_VERSION") or f"{FACE_MODEL}/deepface-{version('deepface')}"
 DISTANCE_METRIC = "cosine"
 FACE_DETECTOR_BACKEND = "opencv"
+# Height of the eyes in the face box of a frontal face, as a fraction from the top
+FRONTAL_EYE_HEIGHT = 0.4
 
 logger.info(f"Loading facial model: {FACE_MODEL}...")
 DeepFace.build_model(FACE_MODEL)
@@ -25,10 +56,17 @@ logger.info("Facial model loaded successfully.")
 # --- Pydantic Models for JSON Payloads ---
 class DetectFacePayload(BaseModel):
     img: str  # The registered image as a Base64 string
+    min_face_ratio: float = 0.5  # Minimum face height / image height
+
+class EmbedPayload(BaseModel):
+    img: str  # Base64 image containing exactly one face
 
 class VerifyFacePayload(BaseModel):
-    regimg: str
+    regimg: str  # URL of the registered image, or a Base64 image (e.g. an ID photo)
     verimg: str
+    anti_spoofing: bool = False  # Run anti-spoofing on the verification image
+    min_face_ratio: float = 0.5  # Minimum face height / image height on the verification image
+    compare_anti_spoofing: bool = True  # Reject spoofs of either image while comparing (off for ID document photos)
 
 # --- Helper function ---
 def read_image_from_url(url: str) -> np.ndarray:
@@ -45,7 +83,7 @@ def read_image_from_url(url: str) -> np.ndarray:
         return img
     except Exception as e:
         logger.error(f"Error reading image from URL '{url}': {e}")
-        raise HTTPException(status_code=400, detail=f"Could not fetch or read image from URL: {str(e)}")
+        raise ServiceError(400, "reference_unavailable", f"Could not fetch or read image from URL: {str(e)}")
 
 def read_image_from_base64(b64_string: str) -> np.ndarray:
     """Decodes a Base64 string into an OpenCV-compatible image."""
@@ -62,35 +100,93 @@ def read_image_from_base64(b64_string: str) -> np.ndarray:
         return img
     except Exception as e:
         logger.error(f"Error reading Base64 image: {e}")
-        raise HTTPException(status_code=400, detail=f"Invalid Base64 image: {str(e)}")
+        raise ServiceError(400, "invalid_image", f"Invalid Base64 image: {str(e)}")
+
+def estimate_head_pose(facial_area: dict) -> dict:
+    """
+    Estimates head yaw, pitch and roll (in degrees) from the eye landmarks.
+    Roll is the tilt of the line between the eyes; yaw and pitch are derived
+    from how far the eyes' midpoint is shifted from where it sits in the face
+    box of a frontal face, horizontally and vertically. Positive pitch is a
+    head tilted down.
+    Returns an empty dict when the landmarks are unavailable.
+    """
+    left_eye = facial_area.get("left_eye")
+    right_eye = facial_area.get("right_eye")
+    face_width = facial_area.get("w", 0)
+    face_height = facial_area.get("h", 0)
+    if not left_eye or not right_eye or face_width <= 0 or face_height <= 0:
+        return {}
+
+    dx = left_eye[0] - right_eye[0]
+    dy = left_eye[1] - right_eye[1]
+    roll = math.degrees(math.atan2(dy, dx))
+
+    eyes_mid_x = (left_eye[0] + right_eye[0]) / 2
+    face_center_x = facial_area.get("x", 0) + face_width / 2
+    offset = (eyes_mid_x - face_center_x) / (face_width / 2)
+    yaw = math.degrees(math.asin(max(-1.0, min(1.0, offset * 2))))
+
+    eyes_mid_y = (left_eye[1] + right_eye[1]) / 2
+    frontal_eyes_y = facial_area.get("y", 0) + face_height * FRONTAL_EYE_HEIGHT
+    offset = (eyes_mid_y - frontal_eyes_y) / (face_height / 2)
+    pitch = math.degrees(math.asin(max(-1.0, min(1.0, offset * 2))))
+
+    return {"yaw": round(yaw, 1), "pitch": round(pitch, 1), "roll": round(roll, 1)}
+
+def error_chain_text(e: Exception) -> str:
+    """Joins the messages of e and its causes, as DeepFace wraps the errors of each image."""
+    messages = []
+    while e is not None:
+        messages.append(str(e))
+        e = e.__cause__
+    return " ".join(messages)
 
 # --- Internal Verification Logic ---
-def perform_verification(regimg: np.ndarray, verimg: np.ndarray) -> dict:
+def perform_verification(regimg: np.ndarray, verimg: np.ndarray, anti_spoofing: bool = False, min_face_ratio: float = 0.5, compare_anti_spoofing: bool = True) -> dict:
     """ Runs DeepFace.verify and returns a structured dictionary. """
     
     ver_img_height = verimg.shape[0]
     
     try:
+        # 1. Optionally check the verification image is a live face.
+        # The verdict is returned to the caller, which decides whether to reject.
+        probe_liveness = {}
+        if anti_spoofing:
+            probe_face = DeepFace.extract_faces(img_path=verimg, anti_spoofing=True)[0]
+            probe_liveness = {
+                "probe_is_real": bool(probe_face.get("is_real", True)),
+                "probe_antispoof_score": float(probe_face.get("antispoof_score", 0)),
+            }
+            logger.info(f"Verification Image - Anti-spoof: {probe_liveness}")
+
+        # 2. Compare the two faces
         result = DeepFace.verify(
             img1_path=regimg,
             img2_path=verimg,
             model_name=FACE_MODEL,
-            anti_spoofing=True
+            anti_spoofing=compare_anti_spoofing
         )
 
         # 3. Check Face/Image Ratio on Verification Image (img2)
         facial_areas = result.get("facial_areas", {})
         img2_area = facial_areas.get("img2", {})
         face_height = img2_area.get("h", 0)
+        probe_face_size = {"width": img2_area.get("w", 0), "height": face_height}
         
         if face_height > 0:
             ratio = face_height / ver_img_height
             logger.info(f"Verification Image - ImgH: {ver_img_height}, FaceH: {face_height}, Ratio: {ratio:.2f}")
             
-            if ratio < 0.50:
+            if ratio < min_face_ratio:
                 raise HTTPException(
                     status_code=400, 
-                    detail=f"Face is too small ({int(ratio*100)}%). Please move closer (target: 50%+)."
+                    detail={
+                        "reason": "face_too_small",
+                        "message": f"Face is too small ({int(ratio*100)}%). Please move closer (target: {int(min_face_ratio*100)}%+).",
+                        "face_size": probe_face_size,
+                        "image_size": {"width": verimg.shape[1], "height": ver_img_height}
+                    }
                 )
 
         return {
@@ -98,21 +194,45 @@ def perform_verification(regimg: np.ndarray, verimg: np.ndarray) -> dict:
             "distance": result["distance"],
             "threshold": result["threshold"],
             "time": result["time"],
-            "ratio": round(ratio, 2)
+            "model": FACE_MODEL,
+            "model_version": MODEL_VERSION,
+            "ratio": round(ratio, 2),
+            "probe_face_size": probe_face_size,
+            **probe_liveness
         }
 
     except ValueError as e:
         # This catches "Face could not be detected" errors from DeepFace
         logger.warning(f"Verification failed: {str(e)}")
-        raise HTTPException(status_code=400, detail=f"Face detection error: {str(e)}")
+        # DeepFace.verify also raises ValueError when an image is a spoof
+        if "spoof" in error_chain_text(e).lower():
+            raise HTTPException(
+                status_code=400,
+                detail={"reason": "spoof_detected", "message": "Spoof detected. Please provide a live, real photo."}
+            )
+        # DeepFace names the image that failed, img1_path being the reference
+        if "img1_path" in str(e):
+            raise HTTPException(
+                status_code=400,
+                detail={"reason": "no_face_in_reference", "message": f"Face detection error: {error_chain_text(e)}"}
+            )
+        raise HTTPException(
+            status_code=400,
+            detail={"reason": "no_face_in_probe", "message": f"Face detection error: {error_chain_text(e)}"}
+        )
     except HTTPException as he:
         raise he
     except Exception as e:
         logger.error(f"An unexpected error occurred: {e}")
-        raise HTTPException(status_code=500, detail=f"Internal server error: {str(e)}")
+        raise ServiceError(500, "internal_error", f"Internal server error: {str(e)}")
 
 # --- API Endpoints ---
 
+@app.get("/health")
+async def health():
+    """Reports that the service is up and the model is loaded."""
+    return {"status": "ok", "model": FACE_MODEL}
+
 # Detect Single Face
 @app.post("/detect-face")
 async def detect_face(payload: DetectFacePayload):
@@ -141,7 +261,10 @@ async def detect_face(payload: DetectFacePayload):
             logger.warning(f"Detection failed: Found {face_count} faces.")
             raise HTTPException(
                 status_code=400, 
-                detail=f"Registration failed: Found {face_count} faces. Please provide a photo with exactly one face."
+                detail={
+                    "reason": "multiple_faces",
+                    "message": f"Registration failed: Found {face_count} faces. Please provide a photo with exactly one face."
+                }
             )
 
         # 3. Success (Exactly 1 face)
@@ -162,50 +285,113 @@ async def detect_face(payload: DetectFacePayload):
         # 4. Check Size (Face Height vs Image Height)
         facial_area = face_data.get("facial_area", {})
         face_height = facial_area.get("h", 0)
+        face_size = {"width": facial_area.get("w", 0), "height": face_height}
+        image_size = {"width": img_width, "height": img_height}
         
         height_ratio = face_height / img_height
-        MIN_HEIGHT_RATIO = 0.50 # 50%
 
         logger.info(f"ImgH: {img_height}, FaceH: {face_height}, Ratio: {height_ratio:.2f}")
 
-        if height_ratio < MIN_HEIGHT_RATIO:
+        if height_ratio < payload.min_face_ratio:
             raise HTTPException(
                 status_code=400,
-                detail=f"Face is too small/far away ({int(height_ratio*100)}%). Please move closer (target: 50%+)."
+                detail={
+                    "reason": "face_too_small",
+                    "message": f"Face is too small/far away ({int(height_ratio*100)}%). Please move closer (target: {int(payload.min_face_ratio*100)}%+).",
+                    "face_size": face_size,
+                    "image_size": image_size
+                }
             )
 
         # 5. Success
         logger.info("Single real face detected successfully.")
         
+        # 6. Estimate head pose so the caller can reject non-frontal faces
+        head_pose = estimate_head_pose(facial_area)
+        logger.info(f"Head pose: {head_pose}")
+
         return {
             "status": "success",
             "is_real": is_real,
             "antispoof_score": antispoof_score,
-            "face_height_ratio": round(height_ratio, 2)
+            "face_height_ratio": round(height_ratio, 2),
+            "face_size": face_size,
+            "image_size": image_size,
+            "head_pose": head_pose or None
         }
 
     except ValueError as e:
         # DeepFace raises ValueError if 0 faces are found (when enforce_detection=True)
         logger.warning(f"Detection failed: No face found. {e}")
-        raise HTTPException(status_code=400, detail="No face detected in the image. Please try again.")
+        raise HTTPException(
+            status_code=400,
+            detail={"reason": "no_face", "message": "No face detected in the image. Please try again."}
+        )
         
     except HTTPException as http_exc:
         raise http_exc
         
     except Exception as e:
         logger.error(f"Unexpected error in /detect-face: {e}")
-        raise HTTPException(status_code=500, detail=f"Internal server error: {str(e)}")
+        raise ServiceError(500, "internal_error", f"Internal server error: {str(e)}")
+
+@app.post("/embed")
+async def embed_face(payload: EmbedPayload):
+    """
+    Returns the embedding of the single face in the image, for callers doing
+    their own matching. Liveness is checked by the API beforehand.
+    """
+    logger.info("Received request for /embed")
+
+    img_arr = read_image_from_base64(payload.img)
+
+    try:
+        representations = DeepFace.represent(
+            img_path=img_arr,
+            model_name=FACE_MODEL,
+            detector_backend=FACE_DETECTOR_BACKEND,
+            enforce_detection=True
+        )
+    except ValueError as e:
+        logger.warning(f"Embedding failed: No face found. {e}")
+        raise HTTPException(
+            status_code=400,
+            detail={"reason": "no_face", "message": "No face detected in the image. Please try again."}
+        )
+    except Exception as e:
+        logger.error(f"Unexpected error in /embed: {e}")
+        raise ServiceError(500, "internal_error", f"Internal server error: {str(e)}")
+
+    if len(representations) > 1:
+        raise HTTPException(
+            status_code=400,
+            detail={
+                "reason": "multiple_faces",
+                "message": f"Found {len(representations)} faces. Please provide a photo with exactly one face."
+            }
+        )
+
+    return {
+        "embedding": representations[0]["embedding"],
+        "model": FACE_MODEL,
+        "model_version": MODEL_VERSION,
+        "distance_metric": DISTANCE_METRIC
+    }
 
 @app.post("/verify") # NEW URL endpoint
 async def verify_face(payload: VerifyFacePayload):
     logger.info("Received request for /verify (JSON)")
 
-    baseimage = read_image_from_url(payload.regimg)
+    if payload.regimg.startswith(("http://", "https://")):
+        baseimage = read_image_from_url(payload.regimg)
+    else:
+        baseimage = read_image_from_base64(payload.regimg)
     ver_arr = read_image_from_base64(payload.verimg)
 
-    result = perform_verification(baseimage, ver_arr)
+    result = perform_verification(baseimage, ver_arr, payload.anti_spoofing, payload.min_face_ratio, payload.compare_anti_spoofing)
     return result
 
 if __name__ == "__main__":
-    logger.info("Starting face verification service on http://localhost:8001")
-    uvicorn.run(app, host="0.0.0.0", port=8001)
+    port = int(os.environ.get("PORT", "8001"))
+    logger.info(f"Starting face verification service on http://localhost:{port}")
+    uvicorn.run(app, host="0.0.0.0", port=port)